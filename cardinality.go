@@ -0,0 +1,158 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this cardinality.go file caps the number of counter and value names
+// so one bug putting e.g. a user ID in a name can't grow the maps
+// without bound.  Names over the limit are folded into __overflow__.
+
+import (
+	"log"
+	"strings"
+)
+
+// OverflowName is the counter (and value) that new names are folded
+// into once a cardinality limit is hit.
+const OverflowName = "__overflow__"
+
+// rejectedName counts the new names turned away by the limits.
+const rejectedName = "0_cardinality_rejected"
+
+// cardinality is the limits and the current counts; guarded by
+// Registry.limitMu.
+type cardinality struct {
+	max      int            // 0 is no limit
+	prefixes map[string]int // prefix to its limit
+	n        int
+	byPrefix map[string]int // prefix to its current count
+	warned   bool
+}
+
+// SetCardinalityLimit caps the number of distinct counter and value
+// names (including the name/suffix and label breakdowns); 0, the
+// default, is no limit.  Once it is hit new names are counted in
+// __overflow__ instead, a warning is logged once and each rejection
+// is counted in 0_cardinality_rejected.
+func (r *Registry) SetCardinalityLimit(maxNames int) {
+	r.limitMu.Lock()
+	r.limits.max = maxNames
+	r.limitMu.Unlock()
+}
+
+// SetCardinalityLimitPrefix caps the number of distinct names
+// starting with prefix the same way; 0 removes the prefix's limit.
+// Only names made after the call are counted against it.
+func (r *Registry) SetCardinalityLimitPrefix(prefix string, maxNames int) {
+	r.limitMu.Lock()
+	defer r.limitMu.Unlock()
+
+	if r.limits.prefixes == nil {
+		r.limits.prefixes = make(map[string]int)
+		r.limits.byPrefix = make(map[string]int)
+	}
+
+	if maxNames == 0 {
+		delete(r.limits.prefixes, prefix)
+		delete(r.limits.byPrefix, prefix)
+
+		return
+	}
+
+	r.limits.prefixes[prefix] = maxNames
+}
+
+// admit reports whether a new name key can be made and counts it.
+func (r *Registry) admit(key string) bool {
+	r.limitMu.Lock()
+	defer r.limitMu.Unlock()
+
+	l := &r.limits
+
+	if l.max > 0 && l.n >= l.max {
+		return r.reject(key)
+	}
+
+	for prefix, maxNames := range l.prefixes {
+		if strings.HasPrefix(key, prefix) && l.byPrefix[prefix] >= maxNames {
+			return r.reject(key)
+		}
+	}
+
+	l.n++
+
+	for prefix := range l.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			l.byPrefix[prefix]++
+		}
+	}
+
+	return true
+}
+
+// reject warns the first time.  Must hold limitMu.
+func (r *Registry) reject(key string) bool {
+	if !r.limits.warned {
+		r.limits.warned = true
+
+		log.Println("counters: cardinality limit hit at", key, "new names go to", OverflowName)
+	}
+
+	return false
+}
+
+// release un-counts a removed name key.
+func (r *Registry) release(key string) {
+	r.limitMu.Lock()
+	defer r.limitMu.Unlock()
+
+	r.limits.n--
+
+	for prefix := range r.limits.prefixes {
+		if strings.HasPrefix(key, prefix) && r.limits.byPrefix[prefix] > 0 {
+			r.limits.byPrefix[prefix]--
+		}
+	}
+}
+
+// counterFor returns the counter at key in m, making it with mk if it
+// is allowed or else returning the overflow counter.
+func (r *Registry) counterFor(m *shardedMap[*counter], key string, mk func() *counter) *counter {
+	c, ok := m.getOrTryMake(key, func() (*counter, bool) {
+		if !r.admit(key) {
+			return nil, false
+		}
+
+		c := mk()
+		c.admitted = true // so removing it releases it
+
+		return c, true
+	})
+	if !ok {
+		r.countersByName.getOrMake(rejectedName, func() *counter { return makeCounter(rejectedName, "", nil) }).add(1)
+
+		return r.countersByName.getOrMake(OverflowName, func() *counter { return makeCounter(OverflowName, "", nil) })
+	}
+
+	return c
+}
+
+// valueFor is counterFor for values.
+func (r *Registry) valueFor(m *shardedMap[*value], key string, mk func() *value) *value {
+	v, ok := m.getOrTryMake(key, func() (*value, bool) {
+		if !r.admit(key) {
+			return nil, false
+		}
+
+		v := mk()
+		v.admitted = true
+
+		return v, true
+	})
+	if !ok {
+		r.countersByName.getOrMake(rejectedName, func() *counter { return makeCounter(rejectedName, "", nil) }).add(1)
+
+		return r.valuesByName.getOrMake(OverflowName, func() *value { return makeValue(OverflowName, "", nil, ValueLast) })
+	}
+
+	return v
+}
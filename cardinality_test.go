@@ -0,0 +1,125 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"strconv"
+	"testing"
+)
+
+func TestCardinalityLimit(t *testing.T) {
+	r := NewRegistry()
+	base := r.countersByName.len() + r.valuesByName.len()
+
+	r.SetCardinalityLimit(base + 5)
+
+	for i := range 10 {
+		r.IncrSyncSuffix("user_"+strconv.Itoa(i), "test")
+	}
+
+	if got := r.ReadSync("user_4"); got != 1 {
+		t.Errorf("Expected user_4 to be admitted, got %d", got)
+	}
+
+	if got := r.ReadSync(OverflowName); got != 5 {
+		t.Errorf("Expected 5 folded into overflow, got %d", got)
+	}
+
+	if got := r.ReadSync(rejectedName); got != 5 {
+		t.Errorf("Expected 5 rejected, got %d", got)
+	}
+
+	// existing names still count
+	r.IncrSyncSuffix("user_0", "test")
+
+	if got := r.ReadSync("user_0"); got != 2 {
+		t.Errorf("Expected user_0 to keep counting, got %d", got)
+	}
+
+	r.LogCounters()
+}
+
+func TestCardinalityLimitPrefix(t *testing.T) {
+	r := NewRegistry()
+	r.SetCardinalityLimitPrefix("req_", 2)
+
+	for i := range 4 {
+		r.IncrSyncSuffix("req_"+strconv.Itoa(i), "test")
+		r.IncrSyncSuffix("other_"+strconv.Itoa(i), "test")
+	}
+
+	if got := r.ReadSync(OverflowName); got != 2 {
+		t.Errorf("Expected 2 req_ names folded into overflow, got %d", got)
+	}
+
+	if got := r.ReadSync("other_3"); got != 1 {
+		t.Errorf("Expected other_ names to be unlimited, got %d", got)
+	}
+
+	r.getOrMakeAndSetValue("req_value", "test", 1.0)
+
+	if v, ok := r.valuesByName.get(OverflowName); !ok || v.data != 1.0 {
+		t.Errorf("Expected the value to be folded into overflow")
+	}
+}
+
+// TestCardinalityBuckets checks only admitted buckets are noted and
+// that removed or expired ones are forgotten.
+func TestCardinalityBuckets(t *testing.T) {
+	r := NewRegistry()
+	r.SetCardinalityLimitPrefix("lat_", 1)
+	r.SetIdleExpiry(1)
+
+	r.MarkDistributionSyncSuffix("lat_a", 5, "a")
+	r.MarkDistributionSyncSuffix("lat_a", 5000, "a")
+	r.NewDistribution("lat_a").Mark(50)
+	r.MarkDistributionLabels("lat_a", 500, L("k", "v"))
+	r.MarkDistributionSyncSuffix("other", 5, "a")
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+
+	if got := r.buckets.len(); got != 2 {
+		t.Errorf("Expected only the 2 admitted buckets noted, got %d", got)
+	}
+
+	r.Remove("lat_a")
+
+	if got := r.buckets.len(); got != 1 {
+		t.Errorf("Expected lat_a's bucket forgotten, got %d", got)
+	}
+
+	r.LogCounters() // other changes from 0
+	r.LogCounters() // other idle
+
+	if got := r.buckets.len(); got != 0 {
+		t.Errorf("Expected other's bucket forgotten on expiry, got %d", got)
+	}
+}
+
+// TestCardinalityRemoveOverflow checks removing the overflow and
+// rejected counters, which were never admitted, frees no room.
+func TestCardinalityRemoveOverflow(t *testing.T) {
+	r := NewRegistry()
+	base := r.countersByName.len() + r.valuesByName.len()
+
+	r.SetCardinalityLimit(base + 2)
+
+	for i := range 4 {
+		r.IncrSyncSuffix("over_"+strconv.Itoa(i), "test")
+	}
+
+	r.getOrMakeAndSetValue("over_val", "test", 1)
+	r.Remove(OverflowName)
+	r.Remove(rejectedName)
+
+	for i := range 4 {
+		r.IncrSyncSuffix("again_"+strconv.Itoa(i), "test")
+	}
+
+	if got := r.ReadSync(OverflowName); got != 4 {
+		t.Errorf("Expected all 4 new names folded into overflow, got %d", got)
+	}
+}
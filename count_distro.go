@@ -8,6 +8,9 @@ package counters
 import (
 	"fmt"
 	"math"
+	"slices"
+	"strconv"
+	"strings"
 )
 
 // Resolution is a function type used with some
@@ -15,8 +18,6 @@ import (
 // user to choose histogram bucket resolution.
 type Resolution func(float64, int, string) string
 
-var theResolution = HighRes
-
 var units = []string{"f", "p", "n", "mi", "m", "", "k", "M", "G", "T", "P"}
 
 var unitSort = []string{"a", "b", "c", "d", "e", "f", "g", "h", "i", "j", "k"}
@@ -160,11 +161,12 @@ func HighRes(shortVal float64, size3 int, unit string) string { //nolint:cyclop
 
 // SetResolution lets the library caller to specify
 // histogram bucket resolution.
-func SetResolution(f Resolution) {
-	theResolution = f
+func (r *Registry) SetResolution(f Resolution) {
+	r.resolution.Store(f)
+	r.resolutionGen.Add(1)
 }
 
-func deriveDistName(name string, value float64) string {
+func (r *Registry) deriveDistName(name string, value float64) string {
 	if value == 0.0 {
 		return name + " [zero]"
 	}
@@ -190,36 +192,178 @@ func deriveDistName(name string, value float64) string {
 		unit = "handleOddSizes(string, value)"
 	}
 
-	s := theResolution(shortVal, size3, unit)
+	resolution, ok := r.resolution.Load().(Resolution)
+	if !ok || resolution == nil {
+		resolution = HighRes
+	}
+
+	s := resolution(shortVal, size3, unit)
 	res = name + sign + unitOrder + "[" + s + "]"
 
 	return res
 }
 
+// bucketInfo is what a distribution bucket counter is a bucket of.
+type bucketInfo struct {
+	dist   string // the distribution's name
+	bucket string // the range as in the counter name, e.g. 001.1k-1.2k
+	lower  float64
+	upper  float64
+	ok     bool // false if the bounds couldn't be worked out
+}
+
+// bucketCounter is the merged counter for the bucket derived of the
+// distribution name, noting it as a bucket once it is admitted.
+func (r *Registry) bucketCounter(name string, derived string) *counter {
+	c := r.getOrMakeCounter(r.countersByName, derived, derived, "", nil)
+	if c.name == derived { // not the overflow counter
+		r.noteBucket(name, derived)
+	}
+
+	return c
+}
+
+// noteBucket remembers that the counter derived is a bucket of the
+// distribution name.  removeCounterKey forgets it.
+func (r *Registry) noteBucket(name string, derived string) {
+	if _, ok := r.buckets.get(derived); ok {
+		return
+	}
+
+	r.buckets.getOrMake(derived, func() *bucketInfo { return parseBucket(name, derived) })
+}
+
+// parseBucket works the bounds back out of a name made by
+// deriveDistName so that any Resolution works.
+func parseBucket(name string, derived string) *bucketInfo {
+	b := &bucketInfo{dist: name}
+	rest := strings.TrimPrefix(derived, name)
+
+	if rest == " [zero]" {
+		b.bucket, b.ok = "zero", true
+
+		return b
+	}
+
+	negative := strings.HasPrefix(rest, "-")
+
+	open := strings.IndexByte(rest, '[')
+	end := strings.LastIndexByte(rest, ']')
+
+	if open < 0 || end < open {
+		return b
+	}
+
+	b.bucket = rest[open+1 : end]
+
+	lo, hi, found := strings.Cut(b.bucket, "-")
+	if !found {
+		return b
+	}
+
+	lower, ok1 := parseUnitNumber(lo)
+	upper, ok2 := parseUnitNumber(hi)
+	b.ok = ok1 && ok2
+
+	if negative {
+		lower, upper = -upper, -lower
+	}
+
+	b.lower, b.upper = lower, upper
+
+	return b
+}
+
+// parseUnitNumber turns e.g. 001.1k or 500mi back into a float64.
+func parseUnitNumber(s string) (float64, bool) {
+	i := strings.IndexFunc(s, func(c rune) bool { return (c < '0' || c > '9') && c != '.' })
+	if i < 0 {
+		i = len(s)
+	}
+
+	for j, u := range units {
+		if u == s[i:] {
+			// via the exponent so e.g. 1.1k is exactly 1100
+			f, err := strconv.ParseFloat(s[:i]+"e"+strconv.Itoa(3*(j-5)), 64) //nolint:mnd
+
+			return f, err == nil
+		}
+	}
+
+	return 0, false
+}
+
+// SampleObserver is told of every value marked in a distribution,
+// e.g. to pass the raw samples on as StatsD does.  It is called on
+// the marking go routine so must be quick.
+type SampleObserver interface {
+	ObserveSample(name string, value float64, suffix string, labels []Label)
+}
+
+// AddSampleObserver adds o to the SampleObservers.  o must be
+// comparable (e.g. a pointer) to be removed again.
+func (r *Registry) AddSampleObserver(o SampleObserver) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	cowUpdate(&r.observers, func(old []SampleObserver) []SampleObserver {
+		return append(slices.Clone(old), o)
+	})
+}
+
+// RemoveSampleObserver removes o, as passed to AddSampleObserver.
+func (r *Registry) RemoveSampleObserver(o SampleObserver) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	cowUpdate(&r.observers, func(old []SampleObserver) []SampleObserver {
+		return slices.DeleteFunc(slices.Clone(old), func(x SampleObserver) bool { return x == o })
+	})
+}
+
+// observeSample tells the SampleObservers of a marked value.
+func (r *Registry) observeSample(name string, value float64, suffix string, labels []Label) {
+	obs := r.observers.Load()
+	if obs == nil {
+		return
+	}
+
+	for _, o := range *obs {
+		o.ObserveSample(name, value, suffix, labels)
+	}
+}
+
 // MarkDistribution transforms the name and value
-// to a histogram bucket and marks it.
-func MarkDistribution(name string, value float64) {
-	derived := deriveDistName(name, value)
-	Incr(derived)
+// to a histogram bucket and marks it.  SetSuffixMode(name, PerCaller)
+// breaks the buckets down by caller.
+func (r *Registry) MarkDistribution(name string, value float64) {
+	suffix := getCallerFunctionName()
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, suffix, nil)
+	r.sendCounter(counterMsg{name: derived, suffix: suffix, i: 1, dist: name})
 }
 
 // MarkDistributionSuffix transforms the name and value to a histogram
 // bucket and marks it, taking a suffix for efficiency.
-func MarkDistributionSuffix(name string, value float64, suffix string) {
-	derived := deriveDistName(name, value)
-	IncrSuffix(derived, suffix)
+func (r *Registry) MarkDistributionSuffix(name string, value float64, suffix string) {
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, suffix, nil)
+	r.sendCounter(counterMsg{name: derived, suffix: suffix, i: 1, dist: name})
 }
 
 // MarkDistributionSync is the faster API
 // One line does it all.
-func MarkDistributionSync(name string, value float64) {
-	derived := deriveDistName(name, value)
-	IncrSync(derived)
+func (r *Registry) MarkDistributionSync(name string, value float64) {
+	suffix := getCallerFunctionName()
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, suffix, nil)
+	r.incrBucket(name, derived, suffix, 1)
 }
 
 // MarkDistributionSyncSuffix is the fastest API
 // One line does it all.
-func MarkDistributionSyncSuffix(name string, value float64, suffix string) {
-	derived := deriveDistName(name, value)
-	IncrSyncSuffix(derived, suffix)
+func (r *Registry) MarkDistributionSyncSuffix(name string, value float64, suffix string) {
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, suffix, nil)
+	r.incrBucket(name, derived, suffix, 1)
 }
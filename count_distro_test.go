@@ -122,7 +122,7 @@ func TestDerivDistNameLow(t *testing.T) {
 	for _, te := range testsDerived {
 		SetResolution(LowRes)
 
-		s := deriveDistName(te.name, te.value)
+		s := theCtx.deriveDistName(te.name, te.value)
 		if s != te.lowResDerived {
 			fmt.Println("Got", s, "Expected", te.lowResDerived, "from", te.value)
 			t.Fail()
@@ -134,7 +134,7 @@ func TestDerivDistMedium(t *testing.T) {
 	for _, te := range testsDerived {
 		SetResolution(MediumRes)
 
-		s := deriveDistName(te.name, te.value)
+		s := theCtx.deriveDistName(te.name, te.value)
 		if s != te.mediumResDerived {
 			fmt.Println("Got", s, "Expected", te.mediumResDerived, "from", te.value)
 			t.Fail()
@@ -146,11 +146,11 @@ func TestDerivDistNameHigh(t *testing.T) {
 	for _, te := range testsDerived {
 		SetResolution(HighRes)
 
-		s := deriveDistName(te.name, te.value)
+		s := theCtx.deriveDistName(te.name, te.value)
 		if s != te.highResDerived {
 			fmt.Println("Got", s, "Expected", te.highResDerived, "from", te.value)
 			t.Fail()
-			deriveDistName(te.name, te.value)
+			theCtx.deriveDistName(te.name, te.value)
 		}
 	}
 }
@@ -6,43 +6,41 @@ package counters
 import (
 	"fmt"
 	"log"
+	"strings"
 	"sync/atomic"
 )
 
-var numCalled uint32
-
 // Incr is the main API - will create counter, and add one to it, as needed.
 // One line does it all.
-func Incr(name string) {
-	IncrDelta(name, 1)
+func (r *Registry) Incr(name string) {
+	r.IncrDeltaSuffix(name, 1, getCallerFunctionName())
 }
 
 // IncrSuffix allows you to do an Incr without runtime lookup of the
 // caller for the suffix.
-func IncrSuffix(name string, suffix string) {
-	IncrDeltaSuffix(name, 1, suffix)
+func (r *Registry) IncrSuffix(name string, suffix string) {
+	r.IncrDeltaSuffix(name, 1, suffix)
 }
 
 // IncrSync is the faster API - will create counter, and add one to it, as needed.
 // One line does it all.
-func IncrSync(name string) {
-	IncrDeltaSync(name, 1)
+func (r *Registry) IncrSync(name string) {
+	r.IncrDeltaSyncSuffix(name, 1, getCallerFunctionName())
 }
 
 // IncrSyncSuffix is the fastest API - will create counter, and add
 // one to it, as needed.  One line does it all.
-func IncrSyncSuffix(name string, suffix string) {
-	IncrDeltaSyncSuffix(name, 1, suffix)
+func (r *Registry) IncrSyncSuffix(name string, suffix string) {
+	r.IncrDeltaSyncSuffix(name, 1, suffix)
 }
 
 // IncrDelta is most versatile API - You can add more than 1 to the counter (negative values are fine).
-func IncrDelta(name string, i int64) {
-	suffix := getCallerFunctionName()
-	IncrDeltaSuffix(name, i, suffix)
+func (r *Registry) IncrDelta(name string, i int64) {
+	r.IncrDeltaSuffix(name, i, getCallerFunctionName())
 }
 
-func getChannel() uint32 {
-	newCount := atomic.AddUint32(&numCalled, 1)
+func (r *Registry) getChannel() uint32 {
+	newCount := atomic.AddUint32(&r.numCalled, 1)
 
 	return newCount % numChannels
 }
@@ -50,61 +48,68 @@ func getChannel() uint32 {
 // IncrDeltaSuffix is most versatile API - You can add more than 1 to
 // the counter (negative values are fine) and provide a static/fast
 // suffix for the counter.
-func IncrDeltaSuffix(name string, i int64, suffix string) {
-	j := getChannel()
+func (r *Registry) IncrDeltaSuffix(name string, i int64, suffix string) {
+	r.sendCounter(counterMsg{name: name, suffix: suffix, i: i})
+}
+
+// sendCounter queues cm for the reading go routines.
+func (r *Registry) sendCounter(cm counterMsg) {
+	if !r.accepting.Load() {
+		return
+	}
+
+	j := r.getChannel()
 
 	select {
-	case theCtx.c[j] <- counterMsg{name, suffix, i}:
+	case r.c[j] <- cm:
 		// good
-	default: // bad but ok
+	default: // full, see SetOverflowPolicy
+		r.overflowCounter(j, cm)
 	}
 }
 
 // ReadSync takes a stat name (including suffix) and returns its value.
-func ReadSync(name string) int64 {
-	theCtx.ctxLock.RLock()
-
-	c, ok := theCtx.countersByName[name]
-	if !ok || c == nil {
-		c, ok = theCtx.counters[name]
+func (r *Registry) ReadSync(name string) int64 {
+	c, ok := r.countersByName.get(name)
+	if !ok {
+		c, ok = r.counters.get(name)
 	}
 
-	defer theCtx.ctxLock.RUnlock()
-
 	if !ok {
 		fmt.Println("Can't find", name)
 
 		return 0
 	}
 
-	return atomic.LoadInt64(&c.data)
+	return c.load()
 }
 
 // IncrDeltaSync is faster sync more versatile API - You can add more than 1 to the counter (negative values are fine).
-func IncrDeltaSync(name string, i int64) {
-	suffix := getCallerFunctionName()
-	IncrDeltaSyncSuffix(name, i, suffix)
+func (r *Registry) IncrDeltaSync(name string, i int64) {
+	r.IncrDeltaSyncSuffix(name, i, getCallerFunctionName())
 }
 
 // IncrDeltaSyncSuffix is best API.
-func IncrDeltaSyncSuffix(name string, i int64, suffix string) {
-	getOrMakeAndIncrCounter(name, suffix, i)
+func (r *Registry) IncrDeltaSyncSuffix(name string, i int64, suffix string) {
+	r.getOrMakeAndIncrCounter(name, suffix, i)
 }
 
 // Decr is used to decrement a counter made with Incr.
-func Decr(name string) {
-	IncrDelta(name, -1)
+func (r *Registry) Decr(name string) {
+	r.IncrDeltaSuffix(name, -1, getCallerFunctionName())
 }
 
 // DecrSuffix is used to decrement a counter made with Incr with the
 // suffix provided instead of the runtime inspection.
-func DecrSuffix(name string, suffix string) {
-	IncrDeltaSuffix(name, -1, suffix)
+func (r *Registry) DecrSuffix(name string, suffix string) {
+	r.IncrDeltaSuffix(name, -1, suffix)
 }
 
-func logCounter(name string, mc *counter, data int64) {
-	log.Printf(theCtx.fmtString,
+func (r *Registry) logCounter(name string, mc *counter, data int64, rate float64, rus []Rollup) {
+	log.Printf(strings.TrimSuffix(r.fmtString, "\n")+"%s%s\n",
 		name,
 		data,
-		data-mc.oldData)
+		data-mc.oldData,
+		rateColumns(rate, &mc.rates),
+		rollupColumns(rus, true))
 }
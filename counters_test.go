@@ -5,7 +5,9 @@
 package counters
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"sync/atomic"
 	"testing"
 )
@@ -64,6 +66,117 @@ func BenchmarkSyncIncr(b *testing.B) {
 	}
 }
 
+// BenchmarkSyncIncrParallel is IncrSync from every P at once, which
+// the sharded maps and striped cells are for.
+func BenchmarkSyncIncrParallel(b *testing.B) {
+	InitCounters()
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			IncrSyncSuffix("num_of_parallel_things", "bench")
+		}
+	})
+}
+
+// BenchmarkHandleIncrParallel is a Counter handle from every P at once.
+func BenchmarkHandleIncrParallel(b *testing.B) {
+	InitCounters()
+
+	c := NewCounter("num_of_parallel_handle_things")
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			c.Incr()
+		}
+	})
+}
+
+// baselineCtx and baselineIncr are the storage and IncrSync path
+// from before the sharded maps (getOrMakeAndIncrCounter as it was,
+// only moved off the package globals) for BenchmarkBaselineIncrParallel.
+type baselineCtx struct {
+	countersByName map[string]*baselineCounter
+	counters       map[string]*baselineCounter
+	ctxLock        sync.RWMutex
+}
+
+type baselineCounter struct {
+	oldData int64
+	data    int64
+}
+
+func (b *baselineCtx) baselineIncr(name string, suffix string, i int64) {
+	nameOnly := true // true means its in countersByName
+	key := ""
+
+	b.ctxLock.RLock()
+
+	c, ok := b.countersByName[name]
+
+	b.ctxLock.RUnlock()
+
+	if ok && c == nil {
+		b.ctxLock.RLock()
+
+		fullName := name + "/" + suffix
+		nameOnly = false
+		key = fullName
+		c, ok = b.countersByName[fullName]
+
+		b.ctxLock.RUnlock()
+	} else {
+		key = name
+	}
+
+	if !ok { // nolint:nestif
+		b.ctxLock.Lock()
+
+		// another routine might have beat us to setting the counter, check again
+		if nameOnly {
+			c, ok = b.countersByName[key]
+		} else {
+			c, ok = b.counters[key]
+		}
+
+		if !ok {
+			c = &baselineCounter{}
+			c.data = i
+
+			if nameOnly {
+				b.countersByName[key] = c
+			} else {
+				b.counters[key] = c
+			}
+		} else {
+			atomic.AddInt64(&c.data, i)
+		}
+
+		b.ctxLock.Unlock()
+	} else {
+		atomic.AddInt64(&c.data, i)
+	}
+}
+
+// BenchmarkBaselineIncrParallel is BenchmarkSyncIncrParallel on the
+// old storage to compare the two above against.
+func BenchmarkBaselineIncrParallel(b *testing.B) {
+	bc := &baselineCtx{
+		countersByName: map[string]*baselineCounter{},
+		counters:       map[string]*baselineCounter{},
+	}
+
+	b.ResetTimer()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			bc.baselineIncr("num_of_parallel_things", "bench", 1)
+		}
+	})
+}
+
 func TestCounter(t *testing.T) {
 	InitCounters()
 	SetLogInterval(10)
@@ -88,6 +201,11 @@ func TestCounter(t *testing.T) {
 		Decr("num_of_things_2")
 	}
 
+	// the reporters run on their own go routines
+	if err := FlushReporters(context.Background()); err != nil {
+		t.Fatalf("FlushReporters: %v", err)
+	}
+
 	c := atomic.LoadInt32(&cbRan)
 	if c != 1 {
 		fmt.Println("Callback did not run", c)
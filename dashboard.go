@@ -0,0 +1,36 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// this dashboard.go file serves a self-contained HTML page (no CDN)
+// showing the registry live, built on CountersHandler.
+
+//go:embed dashboard.html
+var dashboardHTML []byte
+
+// DashboardHandler returns an http.Handler serving a live dashboard,
+// e.g. http.Handle("/debug/counters", r.DashboardHandler()): sortable
+// tables of the counters, values and meta counters with sparklines of
+// the recent deltas, a bar chart of each distribution's buckets, and
+// auto-refresh.  The page polls the same URL with ?json, which is
+// CountersHandler.
+func (r *Registry) DashboardHandler() http.Handler {
+	api := r.CountersHandler()
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Query().Has("json") {
+			api.ServeHTTP(w, req)
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		_, _ = w.Write(dashboardHTML)
+	})
+}
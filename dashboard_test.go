@@ -0,0 +1,43 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDashboardHandler(t *testing.T) {
+	r := NewRegistry()
+	r.IncrDeltaSyncSuffix("dash_things", 4, "a")
+
+	rec := httptest.NewRecorder()
+	r.DashboardHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/counters", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/html") {
+		t.Errorf("Bad content type %s", ct)
+	}
+
+	page := rec.Body.String()
+	if !strings.Contains(page, "<script>") || !strings.Contains(page, "?json&history=") {
+		t.Errorf("Expected the page polling ?json got %.200s", page)
+	}
+
+	for _, ext := range []string{"<script src", "<link", "https://", "@import"} {
+		if strings.Contains(page, ext) {
+			t.Errorf("Expected no external resources, found %s", ext)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	r.DashboardHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/debug/counters?json&history=5", nil))
+
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Expected JSON got %s", ct)
+	}
+
+	if !strings.Contains(rec.Body.String(), `"dash_things"`) {
+		t.Errorf("Expected dash_things in %s", rec.Body)
+	}
+}
@@ -0,0 +1,424 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+)
+
+// this default.go file keeps the original package level API; each
+// function is a thin wrapper over the same method on the default
+// Registry.
+
+// InitCounters should be called at least once to start the go routines etc.
+func InitCounters() {
+	theCtx.InitCounters()
+}
+
+// Shutdown stops the default registry's go routines after draining
+// the channels and logging one last time; see Registry.Shutdown.
+func Shutdown(ctx context.Context) error {
+	return theCtx.Shutdown(ctx)
+}
+
+// LogCounters prints out the counters.  It is called internally
+// each minute but can be called externally e.g. at process end.
+func LogCounters() {
+	theCtx.LogCounters()
+}
+
+// Incr is the main API - will create counter, and add one to it, as needed.
+// One line does it all.
+func Incr(name string) {
+	theCtx.IncrDeltaSuffix(name, 1, getCallerFunctionName())
+}
+
+// IncrSuffix allows you to do an Incr without runtime lookup of the
+// caller for the suffix.
+func IncrSuffix(name string, suffix string) {
+	theCtx.IncrDeltaSuffix(name, 1, suffix)
+}
+
+// IncrSync is the faster API - will create counter, and add one to it, as needed.
+// One line does it all.
+func IncrSync(name string) {
+	theCtx.IncrDeltaSyncSuffix(name, 1, getCallerFunctionName())
+}
+
+// IncrSyncSuffix is the fastest API - will create counter, and add
+// one to it, as needed.  One line does it all.
+func IncrSyncSuffix(name string, suffix string) {
+	theCtx.IncrDeltaSyncSuffix(name, 1, suffix)
+}
+
+// IncrDelta is most versatile API - You can add more than 1 to the counter (negative values are fine).
+func IncrDelta(name string, i int64) {
+	theCtx.IncrDeltaSuffix(name, i, getCallerFunctionName())
+}
+
+// IncrDeltaSuffix is most versatile API - You can add more than 1 to
+// the counter (negative values are fine) and provide a static/fast
+// suffix for the counter.
+func IncrDeltaSuffix(name string, i int64, suffix string) {
+	theCtx.IncrDeltaSuffix(name, i, suffix)
+}
+
+// IncrDeltaSync is faster sync more versatile API - You can add more than 1 to the counter (negative values are fine).
+func IncrDeltaSync(name string, i int64) {
+	theCtx.IncrDeltaSyncSuffix(name, i, getCallerFunctionName())
+}
+
+// IncrDeltaSyncSuffix is best API.
+func IncrDeltaSyncSuffix(name string, i int64, suffix string) {
+	theCtx.IncrDeltaSyncSuffix(name, i, suffix)
+}
+
+// Decr is used to decrement a counter made with Incr.
+func Decr(name string) {
+	theCtx.IncrDeltaSuffix(name, -1, getCallerFunctionName())
+}
+
+// DecrSuffix is used to decrement a counter made with Incr with the
+// suffix provided instead of the runtime inspection.
+func DecrSuffix(name string, suffix string) {
+	theCtx.IncrDeltaSuffix(name, -1, suffix)
+}
+
+// IncrLabels adds one to the counter name with the given labels in
+// the default registry.
+func IncrLabels(name string, labels ...Label) {
+	theCtx.IncrDeltaLabels(name, 1, labels...)
+}
+
+// IncrDeltaLabels adds i to the counter name with the given labels.
+func IncrDeltaLabels(name string, i int64, labels ...Label) {
+	theCtx.IncrDeltaLabels(name, i, labels...)
+}
+
+// IncrDeltaSyncLabels is the sync version of IncrDeltaLabels.
+func IncrDeltaSyncLabels(name string, i int64, labels ...Label) {
+	theCtx.IncrDeltaSyncLabels(name, i, labels...)
+}
+
+// NewCounter returns a handle for the counter name in the default
+// registry; its Incr and Add are a single atomic op.
+func NewCounter(name string) *Counter {
+	return theCtx.NewCounter(name)
+}
+
+// NewValue returns a handle for the value name in the default registry.
+func NewValue(name string) *Value {
+	return theCtx.NewValue(name)
+}
+
+// NewDistribution returns a handle for the distribution name in the
+// default registry.
+func NewDistribution(name string) *Distribution {
+	return theCtx.NewDistribution(name)
+}
+
+// ReadSync takes a stat name (including suffix) and returns its value.
+func ReadSync(name string) int64 {
+	return theCtx.ReadSync(name)
+}
+
+// Set is the main value API - will create value metric, and get the
+// caller func for suffix, as needed.  One line does it all.
+func Set(name string, val float64) {
+	theCtx.SetSuffix(name, val, getCallerFunctionName())
+}
+
+// SetSuffix is a bit faster API - the func name lookup is a bit slow.
+func SetSuffix(name string, val float64, suffix string) {
+	theCtx.SetSuffix(name, val, suffix)
+}
+
+// SetLabels sets the value name with the given labels.
+func SetLabels(name string, val float64, labels ...Label) {
+	theCtx.SetLabels(name, val, labels...)
+}
+
+// AddValue adds delta to the value name in the default registry,
+// making it a float accumulator.
+func AddValue(name string, delta float64) {
+	theCtx.AddValueSuffix(name, delta, getCallerFunctionName())
+}
+
+// AddValueSuffix is AddValue with the suffix given.
+func AddValueSuffix(name string, delta float64, suffix string) {
+	theCtx.AddValueSuffix(name, delta, suffix)
+}
+
+// SetValueMode sets how the value name is aggregated each interval in
+// the default registry.
+func SetValueMode(name string, m ValueMode) {
+	theCtx.SetValueMode(name, m)
+}
+
+// AddMetaCounter adds in a CB to calculate a new number based on
+// other counters.
+func AddMetaCounter(name string,
+	c1 string,
+	c2 string,
+	f MetaCounterF,
+) {
+	theCtx.AddMetaCounter(name, c1, c2, f)
+}
+
+// SetResolution lets the library caller to specify
+// histogram bucket resolution.
+func SetResolution(f Resolution) {
+	theCtx.SetResolution(f)
+}
+
+// MarkDistribution transforms the name and value
+// to a histogram bucket and marks it.
+func MarkDistribution(name string, value float64) {
+	theCtx.MarkDistributionSuffix(name, value, getCallerFunctionName())
+}
+
+// MarkDistributionSuffix transforms the name and value to a histogram
+// bucket and marks it, taking a suffix for efficiency.
+func MarkDistributionSuffix(name string, value float64, suffix string) {
+	theCtx.MarkDistributionSuffix(name, value, suffix)
+}
+
+// MarkDistributionSync is the faster API
+// One line does it all.
+func MarkDistributionSync(name string, value float64) {
+	theCtx.MarkDistributionSyncSuffix(name, value, getCallerFunctionName())
+}
+
+// MarkDistributionSyncSuffix is the fastest API
+// One line does it all.
+func MarkDistributionSyncSuffix(name string, value float64, suffix string) {
+	theCtx.MarkDistributionSyncSuffix(name, value, suffix)
+}
+
+// MarkDistributionLabels marks the histogram bucket for value in the
+// distribution name with the given labels.
+func MarkDistributionLabels(name string, value float64, labels ...Label) {
+	theCtx.MarkDistributionLabels(name, value, labels...)
+}
+
+// TimeFuncRun runs the function and then
+// marks it in a histogram.
+func TimeFuncRun(name string, f TimeFunc) {
+	theCtx.TimeFuncRunSuffix(name, f, getCallerFunctionName())
+}
+
+// TimeFuncRunSuffix runs the function and then
+// marks it in a histogram.
+func TimeFuncRunSuffix(name string, f TimeFunc, suffix string) {
+	theCtx.TimeFuncRunSuffix(name, f, suffix)
+}
+
+// SetMetricReporter specifies a function to be called once per
+// LogInterval with the names of the current metrics and the last
+// minute delta.
+func SetMetricReporter(fn MetricReporter) {
+	theCtx.SetMetricReporter(fn)
+}
+
+// SetValReporter specifies a function to be called once per
+// LogInterval with the names of the current metrics which are
+// float64s and the last minute delta.
+func SetValReporter(fn ValReporter) {
+	theCtx.SetValReporter(fn)
+}
+
+// SetLogInterval sets the number of seconds to sleep between logs of the counters.
+func SetLogInterval(i float64) {
+	theCtx.SetLogInterval(i)
+}
+
+// SetOverflowPolicy sets what the async API does with a full channel;
+// timeout is only used by OverflowBlockTimeout.
+func SetOverflowPolicy(p OverflowPolicy, timeout time.Duration) {
+	theCtx.SetOverflowPolicy(p, timeout)
+}
+
+// SetSuffixMode sets whether name is split per caller suffix or
+// merged in the default registry.
+func SetSuffixMode(name string, m SuffixMode) {
+	theCtx.SetSuffixMode(name, m)
+}
+
+// SetDefaultSuffixMode sets the suffix mode for every name without
+// its own SetSuffixMode in the default registry.
+func SetDefaultSuffixMode(m SuffixMode) {
+	theCtx.SetDefaultSuffixMode(m)
+}
+
+// SetCardinalityLimit caps the number of distinct counter and value
+// names in the default registry; 0 is no limit.
+func SetCardinalityLimit(maxNames int) {
+	theCtx.SetCardinalityLimit(maxNames)
+}
+
+// SetCardinalityLimitPrefix caps the number of distinct names starting
+// with prefix in the default registry; 0 removes the limit.
+func SetCardinalityLimitPrefix(prefix string, maxNames int) {
+	theCtx.SetCardinalityLimitPrefix(prefix, maxNames)
+}
+
+// Remove deletes the counter and/or value name and its breakdowns
+// from the default registry.
+func Remove(name string) {
+	theCtx.Remove(name)
+}
+
+// Reset zeroes the counter and/or value name and its breakdowns in
+// the default registry.
+func Reset(name string) {
+	theCtx.Reset(name)
+}
+
+// ResetAll zeroes every counter and value in the default registry.
+func ResetAll() {
+	theCtx.ResetAll()
+}
+
+// SetIdleExpiry makes counters and values in the default registry
+// which have not changed for intervals log intervals disappear; 0 is
+// never.
+func SetIdleExpiry(intervals int) {
+	theCtx.SetIdleExpiry(intervals)
+}
+
+// SetFmtString sets the format string to log the counters with.  It must have a %s and two %d.
+func SetFmtString(fs string) {
+	theCtx.SetFmtString(fs)
+}
+
+// Snapshot returns a point in time copy of every metric in the
+// default registry; see Registry.Snapshot.
+func Snapshot() RegistrySnapshot {
+	return theCtx.Snapshot()
+}
+
+// SetRollups sets the windows shown as extra columns by LogCounters
+// and passed to the reporters; see Registry.SetRollups.
+func SetRollups(windows ...time.Duration) {
+	theCtx.SetRollups(windows...)
+}
+
+// AddReporter adds rep to the default registry's Reporters; see
+// Registry.AddReporter.
+func AddReporter(rep Reporter) {
+	theCtx.AddReporter(rep)
+}
+
+// RemoveReporter removes rep from the default registry's Reporters.
+func RemoveReporter(rep Reporter) {
+	theCtx.RemoveReporter(rep)
+}
+
+// AddReporterOptions adds rep to the default registry's Reporters
+// delivered to as set out by opts.
+func AddReporterOptions(rep Reporter, opts ReporterOptions) {
+	theCtx.AddReporterOptions(rep, opts)
+}
+
+// FlushReporters waits until the default registry's reports so far
+// have been delivered; see Registry.FlushReporters.
+func FlushReporters(ctx context.Context) error {
+	return theCtx.FlushReporters(ctx)
+}
+
+// PrometheusHandler returns an http.Handler serving the default
+// registry in the Prometheus text format.
+func PrometheusHandler() http.Handler {
+	return theCtx.PrometheusHandler()
+}
+
+// MarkDistributionExemplar marks the bucket for value in the default
+// registry keeping labels as its exemplar.
+func MarkDistributionExemplar(name string, value float64, labels ...Label) {
+	theCtx.markExemplar(name, value, getCallerFunctionName(), labels)
+}
+
+// SetUnit sets the unit of name in the default registry; see
+// Registry.SetUnit.
+func SetUnit(name string, unit string) {
+	theCtx.SetUnit(name, unit)
+}
+
+// AddSampleObserver adds o to the default registry's SampleObservers.
+func AddSampleObserver(o SampleObserver) {
+	theCtx.AddSampleObserver(o)
+}
+
+// RemoveSampleObserver removes o from the default registry.
+func RemoveSampleObserver(o SampleObserver) {
+	theCtx.RemoveSampleObserver(o)
+}
+
+// NewStatsD sends the default registry to the StatsD agent at addr;
+// see Registry.NewStatsD.
+func NewStatsD(addr string, opts StatsDOptions) (*StatsD, error) {
+	return theCtx.NewStatsD(addr, opts)
+}
+
+// NewGraphite sends the default registry to the Carbon endpoint at
+// addr; see Registry.NewGraphite.
+func NewGraphite(addr string, opts GraphiteOptions) *Graphite {
+	return theCtx.NewGraphite(addr, opts)
+}
+
+// NewInflux writes the default registry to w as InfluxDB line
+// protocol; see Registry.NewInflux.
+func NewInflux(w io.Writer) *Influx {
+	return theCtx.NewInflux(w)
+}
+
+// NewInfluxFile appends the default registry to the file at path as
+// InfluxDB line protocol.
+func NewInfluxFile(path string) (*Influx, error) {
+	return theCtx.NewInfluxFile(path)
+}
+
+// NewInfluxHTTP posts the default registry to an InfluxDB /write
+// endpoint; see Registry.NewInfluxHTTP.
+func NewInfluxHTTP(opts InfluxHTTPOptions) *Influx {
+	return theCtx.NewInfluxHTTP(opts)
+}
+
+// NewOTLP exports the default registry to an OpenTelemetry
+// collector; see Registry.NewOTLP.
+func NewOTLP(opts OTLPOptions) *OTLP {
+	return theCtx.NewOTLP(opts)
+}
+
+// PublishExpvar publishes the default registry in /debug/vars as
+// name; see Registry.PublishExpvar.
+func PublishExpvar(name string) error {
+	return theCtx.PublishExpvar(name)
+}
+
+// ImportExpvar mirrors expvar variables into the default registry;
+// see Registry.ImportExpvar.
+func ImportExpvar(prefix string, every time.Duration) *ExpvarImporter {
+	return theCtx.ImportExpvar(prefix, every)
+}
+
+// CountersHandler returns an http.Handler serving the default
+// registry as JSON; see Registry.CountersHandler.
+func CountersHandler() http.Handler {
+	return theCtx.CountersHandler()
+}
+
+// SnapshotHistory is Snapshot of the default registry with the last
+// n interval deltas.
+func SnapshotHistory(n int) RegistrySnapshot {
+	return theCtx.SnapshotHistory(n)
+}
+
+// DashboardHandler returns an http.Handler serving a live HTML
+// dashboard of the default registry; see Registry.DashboardHandler.
+func DashboardHandler() http.Handler {
+	return theCtx.DashboardHandler()
+}
@@ -0,0 +1,287 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+// this deliver.go file runs each Reporter on its own go routine with
+// a deadline, a bounded queue and retries so a slow or hung sink only
+// holds up itself, not the logging.  Failures are counted in
+// 0_reporter_errors, 0_reporter_timeouts and 0_reporter_skipped.
+
+const (
+	reporterErrorsName   = "0_reporter_errors"
+	reporterTimeoutsName = "0_reporter_timeouts"
+	reporterSkippedName  = "0_reporter_skipped"
+)
+
+// ReporterOptions control how reports are delivered to one Reporter;
+// zero fields get the defaults.
+type ReporterOptions struct {
+	Timeout  time.Duration // per call of Report, default 10s
+	QueueLen int           // reports waiting before new ones are skipped, default 10
+	Retries  int           // after the first failure, default 2; -1 for none
+	Backoff  time.Duration // before the first retry, doubling after, default 1s
+}
+
+func (o ReporterOptions) withDefaults() ReporterOptions {
+	if o.Timeout <= 0 {
+		o.Timeout = 10 * time.Second //nolint:mnd
+	}
+
+	if o.QueueLen <= 0 {
+		o.QueueLen = 10
+	}
+
+	if o.Retries == 0 {
+		o.Retries = 2
+	}
+
+	o.Retries = max(o.Retries, 0)
+
+	if o.Backoff <= 0 {
+		o.Backoff = time.Second
+	}
+
+	return o
+}
+
+// worstCase is the longest a queue of reports can take to deliver.
+func (o ReporterOptions) worstCase() time.Duration {
+	perTry := o.Timeout + o.Backoff<<o.Retries
+
+	return time.Duration(o.QueueLen*(o.Retries+1)) * perTry
+}
+
+// delivery is a report to deliver or, with flushed set, a marker to
+// close once everything before it is delivered.
+type delivery struct {
+	rep     *Report
+	flushed chan struct{}
+}
+
+// reporterRunner is a Reporter and the go routine delivering to it,
+// started when the first report is queued.
+type reporterRunner struct {
+	rep     Reporter
+	opts    ReporterOptions
+	mu      sync.Mutex
+	q       *runQueue // nil when no go routine is running
+	removed bool
+}
+
+// runQueue is the queue of one run of a reporterRunner's go routine.
+// Blocking sends are made without holding the runner's mu, so stop
+// closes quit and waits for them before closing ch.
+type runQueue struct {
+	ch      chan delivery
+	done    chan struct{} // closed when the go routine exits
+	quit    chan struct{} // closed when stop starts
+	sending sync.WaitGroup
+}
+
+func newReporterRunner(rep Reporter, opts ReporterOptions) *reporterRunner {
+	return &reporterRunner{rep: rep, opts: opts.withDefaults()}
+}
+
+// start makes sure the go routine is running.  Must hold x.mu.
+func (x *reporterRunner) start(r *Registry) *runQueue {
+	if x.q == nil && !x.removed {
+		x.q = &runQueue{
+			ch:   make(chan delivery, x.opts.QueueLen),
+			done: make(chan struct{}),
+			quit: make(chan struct{}),
+		}
+
+		go x.run(r, x.q.ch, x.q.done)
+	}
+
+	return x.q
+}
+
+// offer queues rep or counts it as skipped if the queue is full.
+func (x *reporterRunner) offer(r *Registry, rep *Report) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	q := x.start(r)
+	if q == nil {
+		return
+	}
+
+	select {
+	case q.ch <- delivery{rep: rep}:
+	default:
+		r.getOrMakeAndIncrCounter(reporterSkippedName, "reporters", 1)
+	}
+}
+
+// flush waits for everything queued so far to be delivered.
+func (x *reporterRunner) flush(ctx context.Context, r *Registry) error {
+	flushed := make(chan struct{})
+
+	x.mu.Lock()
+	q := x.start(r)
+
+	if q == nil {
+		x.mu.Unlock()
+
+		return nil
+	}
+
+	q.sending.Add(1) // under mu so stop waits for this send
+	x.mu.Unlock()
+
+	select {
+	case q.ch <- delivery{flushed: flushed}:
+	case <-q.quit: // stopping, done is closed once all is delivered
+		flushed = q.done
+	case <-ctx.Done():
+		q.sending.Done()
+
+		return ctx.Err()
+	}
+
+	q.sending.Done()
+
+	select {
+	case <-flushed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stop closes the queue; the go routine delivers what is in it and
+// exits, closing the channel returned.  removed stops it for good.
+// It doesn't wait on the go routine, only on flushes to stop sending.
+func (x *reporterRunner) stop(removed bool) chan struct{} {
+	x.mu.Lock()
+	x.removed = x.removed || removed
+	q := x.q
+	x.q = nil
+	x.mu.Unlock()
+
+	if q == nil {
+		return nil
+	}
+
+	close(q.quit)
+	q.sending.Wait()
+	close(q.ch)
+
+	return q.done
+}
+
+func (x *reporterRunner) run(r *Registry, queue chan delivery, done chan struct{}) {
+	defer close(done)
+
+	for d := range queue {
+		if d.flushed != nil {
+			close(d.flushed)
+
+			continue
+		}
+
+		x.deliver(r, d.rep)
+	}
+}
+
+// deliver calls Report until it works or the retries run out.  A
+// Report that ignores its context's deadline holds up only this
+// Reporter; reports behind it are skipped once the queue is full.
+func (x *reporterRunner) deliver(r *Registry, rep *Report) {
+	backoff := x.opts.Backoff
+
+	for try := 0; ; try++ {
+		ctx, cancel := context.WithTimeout(context.Background(), x.opts.Timeout)
+		err := x.rep.Report(ctx, rep)
+
+		if err == nil {
+			cancel()
+
+			return
+		}
+
+		if errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			r.getOrMakeAndIncrCounter(reporterTimeoutsName, "reporters", 1)
+		} else {
+			r.getOrMakeAndIncrCounter(reporterErrorsName, "reporters", 1)
+		}
+
+		cancel()
+
+		if try >= x.opts.Retries {
+			log.Println("Reporter failed, giving up on the report:", err)
+
+			return
+		}
+
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// sendReport queues rep for each of the reporters.
+func (r *Registry) sendReport(reporters []*reporterRunner, rep *Report) {
+	for _, x := range reporters {
+		x.offer(r, rep)
+	}
+}
+
+// FlushReporters waits until every report made so far has been
+// delivered (or given up on) by every Reporter, or ctx expires.
+func (r *Registry) FlushReporters(ctx context.Context) error {
+	r.ctxLock.RLock()
+	reporters := append([]*reporterRunner(nil), r.reporters...)
+	r.ctxLock.RUnlock()
+
+	for _, x := range reporters {
+		if err := x.flush(ctx, r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// stopReporters stops the reporters' go routines after they have
+// delivered what is queued.  It waits on them all at once, each at
+// most as long as that should take and all no longer than ctx; any
+// still busy are left to finish in the background.
+func (r *Registry) stopReporters(ctx context.Context) {
+	r.ctxLock.RLock()
+	reporters := append([]*reporterRunner(nil), r.reporters...)
+	r.ctxLock.RUnlock()
+
+	var wg sync.WaitGroup
+
+	for _, x := range reporters {
+		done := x.stop(false)
+		if done == nil {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			select {
+			case <-done:
+			case <-ctx.Done():
+				log.Println("Reporter still busy at Shutdown, leaving it")
+			case <-time.After(x.opts.worstCase()):
+				log.Println("Reporter still busy at Shutdown, leaving it")
+			}
+		}()
+	}
+
+	wg.Wait()
+}
@@ -0,0 +1,221 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// logAndFlush is LogCounters then waits for the reporters.
+func logAndFlush(t *testing.T, r *Registry) {
+	t.Helper()
+	r.LogCounters()
+
+	if err := r.FlushReporters(context.Background()); err != nil {
+		t.Fatalf("FlushReporters: %v", err)
+	}
+}
+
+// flakyReporter fails the first fails calls.
+type flakyReporter struct {
+	fails int32
+	calls atomic.Int32
+}
+
+func (f *flakyReporter) Report(_ context.Context, _ *Report) error {
+	if f.calls.Add(1) <= f.fails {
+		return errors.New("flaky")
+	}
+
+	return nil
+}
+
+// hungReporter blocks until its context is done or release is closed.
+type hungReporter struct {
+	release chan struct{}
+	calls   atomic.Int32
+}
+
+func (h *hungReporter) Report(ctx context.Context, _ *Report) error {
+	h.calls.Add(1)
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-h.release:
+		return nil
+	}
+}
+
+func TestReporterRetries(t *testing.T) {
+	r := NewRegistry()
+	f := &flakyReporter{fails: 2}
+
+	r.AddReporterOptions(f, ReporterOptions{Retries: 2, Backoff: time.Millisecond})
+	logAndFlush(t, r)
+
+	if got := f.calls.Load(); got != 3 {
+		t.Errorf("Expected 3 calls got %d", got)
+	}
+
+	if got := r.ReadSync(reporterErrorsName); got != 2 {
+		t.Errorf("Expected 2 errors got %d", got)
+	}
+}
+
+func TestReporterTimeoutAndSkip(t *testing.T) {
+	r := NewRegistry()
+	h := &hungReporter{release: make(chan struct{})}
+	fast := &testReporter{}
+
+	r.AddReporterOptions(h, ReporterOptions{
+		Timeout:  50 * time.Millisecond,
+		QueueLen: 1,
+		Retries:  -1,
+	})
+	r.AddReporter(fast)
+
+	// one being delivered, one queued and the rest skipped
+	for range 4 {
+		r.LogCounters()
+	}
+
+	if err := r.FlushReporters(context.Background()); err != nil {
+		t.Fatalf("FlushReporters: %v", err)
+	}
+
+	if len(fast.reports) != 4 {
+		t.Errorf("Expected the other reporter to get all 4 reports got %d", len(fast.reports))
+	}
+
+	if got := r.ReadSync(reporterTimeoutsName); got < 1 {
+		t.Errorf("Expected timeouts got %d", got)
+	}
+
+	if got := r.ReadSync(reporterSkippedName); got < 1 {
+		t.Errorf("Expected skipped reports got %d", got)
+	}
+
+	if got := int64(h.calls.Load()) + r.ReadSync(reporterSkippedName); got != 4 {
+		t.Errorf("Expected every report delivered or skipped got %d", got)
+	}
+
+	close(h.release)
+}
+
+func TestShutdownDeliversReports(t *testing.T) {
+	r := NewRegistry()
+	tr := &testReporter{}
+
+	r.AddReporter(tr)
+	r.IncrSyncSuffix("delivered", "a")
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if len(tr.reports) != 1 {
+		t.Errorf("Expected the final report delivered by Shutdown got %d", len(tr.reports))
+	}
+}
+
+func TestShutdownBoundsReporters(t *testing.T) {
+	r := NewRegistry()
+	h1 := &hungReporter{release: make(chan struct{})}
+	h2 := &hungReporter{release: make(chan struct{})}
+
+	r.AddReporterOptions(h1, ReporterOptions{Timeout: time.Hour})
+	r.AddReporterOptions(h2, ReporterOptions{Timeout: time.Hour})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+
+	if err := r.Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Expected the deadline got %v", err)
+	}
+
+	r.InitCounters() // waits for the rest of the shutdown
+
+	if took := time.Since(start); took > 5*time.Second {
+		t.Errorf("Expected Shutdown bounded by its context, took %v", took)
+	}
+
+	r.ctxLock.RLock()
+	started := r.started
+	r.ctxLock.RUnlock()
+
+	if !started || !r.accepting.Load() {
+		t.Errorf("Expected InitCounters to start again after a timed out Shutdown")
+	}
+
+	close(h1.release)
+	close(h2.release)
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Errorf("Second Shutdown: %v", err)
+	}
+}
+
+// notBlocked fails t if fn doesn't return; it would never return if
+// it waited on a hung reporter, so the bound is generous.
+func notBlocked(t *testing.T, what string, fn func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		fn()
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("Expected %s not to wait for a hung reporter", what)
+	}
+}
+
+// TestFlushDoesNotBlock checks a flush waiting on a hung reporter's
+// full queue holds up neither LogCounters nor removing a reporter.
+func TestFlushDoesNotBlock(t *testing.T) {
+	r := NewRegistry()
+	h := &hungReporter{release: make(chan struct{})}
+	other := &testReporter{}
+
+	r.AddReporterOptions(h, ReporterOptions{Timeout: time.Hour, QueueLen: 1})
+	r.AddReporter(other)
+
+	r.LogCounters() // being delivered
+
+	for h.calls.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	r.LogCounters() // queued, so the queue is full
+
+	flushed := make(chan error)
+
+	go func() { flushed <- r.FlushReporters(context.Background()) }()
+
+	time.Sleep(50 * time.Millisecond) // for the flush to be waiting on the queue
+
+	notBlocked(t, "LogCounters during a flush", func() {
+		for range 4 {
+			r.LogCounters()
+		}
+	})
+
+	notBlocked(t, "RemoveReporter during a flush", func() { r.RemoveReporter(other) })
+
+	close(h.release)
+
+	if err := <-flushed; err != nil {
+		t.Errorf("FlushReporters: %v", err)
+	}
+}
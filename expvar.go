@@ -0,0 +1,197 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"errors"
+	"expvar"
+	"math"
+	"sync"
+	"time"
+)
+
+// this expvar.go file publishes the registry in /debug/vars and
+// mirrors the other expvar Ints, Floats and Maps into counters and
+// values.
+
+var errExpvarTaken = errors.New("expvar name already published")
+
+// ExpvarVar returns the registry as an expvar.Var: nested JSON of the
+// counters (total and delta), values, meta counters and
+// distributions (bucket totals).
+func (r *Registry) ExpvarVar() expvar.Var {
+	return expvar.Func(func() any { return r.expvarData() })
+}
+
+// publishMu serialises PublishExpvar's check and publish.
+var publishMu sync.Mutex
+
+// PublishExpvar publishes ExpvarVar as name, e.g. "counters", so it
+// is in /debug/vars.  It is an error if name is already published.
+func (r *Registry) PublishExpvar(name string) (err error) {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return errExpvarTaken
+	}
+
+	// someone calling expvar.Publish directly can still get in first
+	defer func() {
+		if recover() != nil {
+			err = errExpvarTaken
+		}
+	}()
+
+	expvar.Publish(name, r.ExpvarVar())
+
+	return nil
+}
+
+type expvarCounter struct {
+	Total int64 `json:"total"`
+	Delta int64 `json:"delta"`
+}
+
+type expvarData struct {
+	Counters      map[string]expvarCounter    `json:"counters"`
+	Values        map[string]any              `json:"values"`
+	Meta          map[string]any              `json:"meta"`
+	Distributions map[string]map[string]int64 `json:"distributions"`
+}
+
+func (r *Registry) expvarData() expvarData {
+	s := r.Snapshot()
+	d := expvarData{
+		Counters:      map[string]expvarCounter{},
+		Values:        map[string]any{},
+		Meta:          map[string]any{},
+		Distributions: map[string]map[string]int64{},
+	}
+
+	for _, c := range s.Counters {
+		d.Counters[c.Name] = expvarCounter{c.Total, c.Delta}
+	}
+
+	for _, v := range s.Values {
+		d.Values[v.Name] = expvarFloat(v.Value)
+	}
+
+	for _, m := range s.Meta {
+		d.Meta[m.Name] = expvarFloat(m.Total)
+	}
+
+	for _, dist := range s.Distributions {
+		name := dist.Name
+		if len(dist.Labels) > 0 {
+			name = labelKey(name, dist.Labels)
+		}
+
+		if dist.Suffix != "" {
+			name += "/" + dist.Suffix
+		}
+
+		buckets := map[string]int64{}
+		for _, b := range dist.Buckets {
+			key := b.Bucket
+			if b.Upper < 0 || (b.Upper == 0 && b.Lower < 0) {
+				key = "-" + key // negative ones share the positive's name
+			}
+
+			buckets[key] = b.Total
+		}
+
+		d.Distributions[name] = buckets
+	}
+
+	return d
+}
+
+// expvarFloat is f or, as JSON has no NaN or Inf, nil.
+func expvarFloat(f float64) any {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return nil
+	}
+
+	return f
+}
+
+// ExpvarImporter mirrors expvar variables into a registry, see
+// ImportExpvar.
+type ExpvarImporter struct {
+	r      *Registry
+	prefix string
+	stop   chan struct{}
+	done   chan struct{}
+	once   sync.Once
+}
+
+// ImportExpvar mirrors every expvar Int into a counter and Float into
+// a value, named prefix+name (Maps' entries are prefix+map.key), now
+// and then every interval until Close.  An interval <= 0 imports just
+// the once; call Import to do it again.
+func (r *Registry) ImportExpvar(prefix string, every time.Duration) *ExpvarImporter {
+	x := &ExpvarImporter{r: r, prefix: prefix, stop: make(chan struct{}), done: make(chan struct{})}
+	x.Import()
+
+	if every <= 0 {
+		close(x.done)
+
+		return x
+	}
+
+	go func() {
+		defer close(x.done)
+
+		t := time.NewTicker(every)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-x.stop:
+				return
+			case <-t.C:
+				x.Import()
+			}
+		}
+	}()
+
+	return x
+}
+
+// Close stops the importing.
+func (x *ExpvarImporter) Close() {
+	x.once.Do(func() { close(x.stop) })
+	<-x.done
+}
+
+// Import does one walk of the expvar variables.
+func (x *ExpvarImporter) Import() {
+	expvar.Do(func(kv expvar.KeyValue) {
+		x.mirror(x.prefix+kv.Key, kv.Value)
+	})
+}
+
+func (x *ExpvarImporter) mirror(name string, v expvar.Var) {
+	switch v := v.(type) {
+	case *expvar.Int:
+		// the counter's total follows the Int, even down
+		c := x.r.getOrMakeCounter(x.r.countersByName, name, name, "", nil)
+		if c.name != name { // the overflow counter, which counts updates
+			c.add(1)
+
+			return
+		}
+
+		c.add(v.Value() - c.load())
+	case *expvar.Float:
+		// the shared overflow value isn't set, the rejection is counted
+		if val := x.r.getOrMakeValue(x.r.valuesByName, name, name, "", nil); val.name == name {
+			val.set(v.Value())
+		}
+	case *expvar.Map:
+		v.Do(func(kv expvar.KeyValue) {
+			x.mirror(name+"."+kv.Key, kv.Value)
+		})
+	}
+}
@@ -0,0 +1,226 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"encoding/json"
+	"expvar"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExpvarVar(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	r.IncrDeltaSyncSuffix("ev_good", 9, "a")
+	r.IncrDeltaSyncSuffix("ev_bad", 1, "a")
+	r.AddMetaCounter("ev_avail", "ev_good", "ev_bad", RatioTotal)
+	r.NewValue("ev_val").Set(2.5)
+	r.NewValue("ev_nan").Set(math.NaN())
+
+	d := r.NewDistribution("ev_dist")
+	d.Mark(1113.0)
+	d.Mark(-1113.0)
+
+	var got struct {
+		Counters map[string]struct {
+			Total int64
+			Delta int64
+		}
+		Values        map[string]*float64
+		Meta          map[string]float64
+		Distributions map[string]map[string]int64
+	}
+
+	if err := json.Unmarshal([]byte(r.ExpvarVar().String()), &got); err != nil {
+		t.Fatalf("Bad JSON %v", err)
+	}
+
+	if c := got.Counters["ev_good"]; c.Total != 9 || c.Delta != 9 {
+		t.Errorf("Expected ev_good 9 got %v", got.Counters)
+	}
+
+	if v := got.Values["ev_val"]; v == nil || *v != 2.5 {
+		t.Errorf("Expected ev_val 2.5 got %v", got.Values)
+	}
+
+	if v, ok := got.Values["ev_nan"]; !ok || v != nil {
+		t.Errorf("Expected ev_nan null got %v", got.Values)
+	}
+
+	if got.Meta["ev_avail"] != 0.9 {
+		t.Errorf("Expected ev_avail 0.9 got %v", got.Meta)
+	}
+
+	b := got.Distributions["ev_dist"]
+	if b["001.1k-1.2k"] != 1 || b["-001.1k-1.2k"] != 1 {
+		t.Errorf("Expected both ev_dist buckets got %v", got.Distributions)
+	}
+
+	if expvar.Get("ev_test_registry") == nil { // once per process
+		if err := r.PublishExpvar("ev_test_registry"); err != nil {
+			t.Fatalf("PublishExpvar: %v", err)
+		}
+	}
+
+	if err := r.PublishExpvar("ev_test_registry"); err == nil {
+		t.Errorf("Expected an error publishing the name twice")
+	}
+}
+
+// expvarInt is the expvar.Int called name, made once per process as
+// expvar can't unpublish.
+func expvarInt(name string) *expvar.Int {
+	if v, ok := expvar.Get(name).(*expvar.Int); ok {
+		return v
+	}
+
+	return expvar.NewInt(name)
+}
+
+func TestImportExpvar(t *testing.T) {
+	r := NewRegistry()
+
+	i := expvarInt("ev_import_int")
+	i.Set(5)
+
+	f := new(expvar.Float)
+	f.Set(1.5)
+
+	m, ok := expvar.Get("ev_import_map").(*expvar.Map)
+	if !ok {
+		m = expvar.NewMap("ev_import_map")
+	}
+
+	m.Set("float", f)
+	m.Add("int", 0)
+	m.Get("int").(*expvar.Int).Set(3)
+
+	x := r.ImportExpvar("imp_", time.Hour)
+	defer x.Close()
+
+	if v := r.ReadSync("imp_ev_import_int"); v != 5 {
+		t.Errorf("Expected imp_ev_import_int 5 got %d", v)
+	}
+
+	if v := r.ReadSync("imp_ev_import_map.int"); v != 3 {
+		t.Errorf("Expected imp_ev_import_map.int 3 got %d", v)
+	}
+
+	i.Set(2)
+	f.Set(7.25)
+	x.Import()
+
+	if v := r.ReadSync("imp_ev_import_int"); v != 2 {
+		t.Errorf("Expected imp_ev_import_int to follow down to 2 got %d", v)
+	}
+
+	found := false
+
+	for _, v := range r.Snapshot().Values {
+		if v.Name == "imp_ev_import_map.float" {
+			found = true
+
+			if v.Value != 7.25 {
+				t.Errorf("Expected imp_ev_import_map.float 7.25 got %v", v.Value)
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("Expected imp_ev_import_map.float in values")
+	}
+
+	once := r.ImportExpvar("once_", 0)
+	once.Close()
+
+	if v := r.ReadSync("once_ev_import_int"); v != 2 {
+		t.Errorf("Expected one import with no interval got %d", v)
+	}
+}
+
+func TestPublishExpvarRace(t *testing.T) {
+	r := NewRegistry()
+	name := "ev_race_" + strconv.Itoa(int(time.Now().UnixNano()))
+
+	var wg sync.WaitGroup
+
+	var ok atomic.Int32
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			if r.PublishExpvar(name) == nil {
+				ok.Add(1)
+			}
+		}()
+	}
+
+	expvar.NewInt(name + "_direct")
+
+	defer func() {
+		if recover() != nil {
+			t.Errorf("Expected no panic")
+		}
+	}()
+
+	if err := r.PublishExpvar(name + "_direct"); err == nil {
+		t.Errorf("Expected an error for a name published directly")
+	}
+
+	wg.Wait()
+
+	if ok.Load() != 1 {
+		t.Errorf("Expected exactly one publish to win got %d", ok.Load())
+	}
+}
+
+// TestImportExpvarOverflow checks imports turned away by the
+// cardinality limit count in the overflow counter, not set it.
+func TestImportExpvarOverflow(t *testing.T) {
+	r := NewRegistry()
+	r.SetCardinalityLimitPrefix("lim_", 1)
+
+	expvarInt("ev_overflow_int").Set(7)
+
+	f, ok := expvar.Get("ev_overflow_float").(*expvar.Float)
+	if !ok {
+		f = expvar.NewFloat("ev_overflow_float")
+	}
+
+	f.Set(2.5)
+
+	r.IncrSync("lim_first")
+	r.IncrDeltaSyncSuffix("lim_folded", 500, "")
+	r.ImportExpvar("lim_", 0).Close()
+
+	ints := int64(0)
+
+	var count func(kv expvar.KeyValue)
+	count = func(kv expvar.KeyValue) {
+		switch v := kv.Value.(type) {
+		case *expvar.Int:
+			ints++
+		case *expvar.Map:
+			v.Do(count)
+		}
+	}
+
+	expvar.Do(count)
+
+	if v := r.ReadSync(OverflowName); v != 500+ints {
+		t.Errorf("Expected the %d imports added to the overflow's 500 got %d", ints, v)
+	}
+
+	if v, ok := r.valuesByName.get(OverflowName); ok && v.N != 0 {
+		t.Errorf("Expected the overflow value not set by imports got %v", v.data)
+	}
+}
@@ -11,7 +11,7 @@ import (
 	"strings"
 )
 
-func checkRuntime() {
+func (r *Registry) checkRuntime() {
 	ms := metrics.All()
 	// next 10 lines from https://pkg.go.dev/runtime/metrics#example-Read-ReadingAllMetrics
 	// Create a sample for each metric.
@@ -44,12 +44,12 @@ func checkRuntime() {
 				continue
 			}
 
-			IncrDeltaSuffix(name, int64(value.Uint64()), "go-runtime") //nolint:gosec
+			r.IncrDeltaSuffix(name, int64(value.Uint64()), "go-runtime") //nolint:gosec
 		} else if value.Kind() == metrics.KindFloat64 {
-			SetSuffix(name, value.Float64(), "go-runtime")
+			r.SetSuffix(name, value.Float64(), "go-runtime")
 		} else if value.Kind() == metrics.KindUint64 {
 			vv := float64(value.Uint64())
-			SetSuffix(name, vv, "go-runtime")
+			r.SetSuffix(name, vv, "go-runtime")
 		}
 	}
 }
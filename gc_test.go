@@ -10,7 +10,7 @@ import (
 )
 
 func TestGcMetrics(_ *testing.T) {
-	checkRuntime()
+	theCtx.checkRuntime()
 	time.Sleep(2 * time.Second)
 	LogCounters()
 }
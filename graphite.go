@@ -0,0 +1,247 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// this graphite.go file sends each interval to a Carbon endpoint in
+// the Graphite plaintext protocol, "path value timestamp" lines over
+// TCP, holding a bounded buffer of lines while disconnected.
+
+// graphiteDroppedName counts lines dropped from a full buffer.
+const graphiteDroppedName = "0_graphite_dropped"
+
+var errGraphiteBackoff = errors.New("graphite: waiting to reconnect")
+
+// GraphiteOptions configure a Graphite; zero fields get the
+// defaults.
+type GraphiteOptions struct {
+	Prefix      string        // put before every path, e.g. "servers.web1."
+	BufferLines int           // lines held while disconnected, default 10000
+	MinBackoff  time.Duration // before the first reconnect, default 1s
+	MaxBackoff  time.Duration // doubling up to, default 1m
+}
+
+// Graphite is a Reporter writing to a Carbon plaintext endpoint.
+// Counters are path.total and path.delta, values and meta counters
+// path, and distribution buckets path.bucket (a delta).  A suffix is
+// another path node; labels are Graphite tags.
+type Graphite struct {
+	r        *Registry
+	addr     string
+	opts     GraphiteOptions
+	dial     func(ctx context.Context) (net.Conn, error)
+	mu       sync.Mutex
+	conn     net.Conn
+	buf      []string // lines not yet written, oldest first
+	lastRep  *Report  // so retries of a report don't buffer it twice
+	backoff  time.Duration
+	nextDial time.Time
+}
+
+// NewGraphite adds a Reporter sending to the Carbon endpoint at addr
+// (host:port).  It connects on the first report.
+func (r *Registry) NewGraphite(addr string, opts GraphiteOptions) *Graphite {
+	if opts.BufferLines <= 0 {
+		opts.BufferLines = 10000 //nolint:mnd
+	}
+
+	if opts.MinBackoff <= 0 {
+		opts.MinBackoff = time.Second
+	}
+
+	if opts.MaxBackoff < opts.MinBackoff {
+		opts.MaxBackoff = max(time.Minute, opts.MinBackoff)
+	}
+
+	g := &Graphite{r: r, addr: addr, opts: opts, backoff: opts.MinBackoff}
+	g.dial = func(ctx context.Context) (net.Conn, error) {
+		var d net.Dialer
+
+		return d.DialContext(ctx, "tcp", g.addr)
+	}
+
+	r.AddReporter(g)
+
+	return g
+}
+
+// Close removes the Graphite from the registry and closes the
+// connection; buffered lines are lost.
+func (g *Graphite) Close() error {
+	g.r.RemoveReporter(g)
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.conn == nil {
+		return nil
+	}
+
+	err := g.conn.Close()
+	g.conn = nil
+
+	return err
+}
+
+// Report buffers the report's lines and writes everything buffered.
+func (g *Graphite) Report(ctx context.Context, rep *Report) error {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if rep != g.lastRep {
+		g.lastRep = rep
+		g.buffer(g.lines(rep))
+	}
+
+	return g.flush(ctx)
+}
+
+// lines renders rep as plaintext lines.
+func (g *Graphite) lines(rep *Report) []string {
+	ts := " " + strconv.FormatInt(rep.Time.Unix(), 10) + "\n"
+	res := []string{}
+
+	add := func(path string, v string) {
+		res = append(res, g.opts.Prefix+path+" "+v+ts)
+	}
+
+	for _, c := range rep.Counters {
+		add(graphitePath(c.Base, c.Suffix, ".total", c.Labels), strconv.FormatInt(c.Total, 10))
+		add(graphitePath(c.Base, c.Suffix, ".delta", c.Labels), strconv.FormatInt(c.Delta, 10))
+	}
+
+	for _, v := range rep.Values {
+		add(graphitePath(v.Base, v.Suffix, "", v.Labels), formatFloat(v.Value))
+	}
+
+	for _, m := range rep.Meta {
+		base, suffix, _ := strings.Cut(m.Name, "/")
+		add(graphitePath(base, suffix, "", nil), formatFloat(m.Total))
+	}
+
+	for _, d := range rep.Distributions {
+		for _, b := range d.Buckets {
+			add(graphitePath(d.Name, d.Suffix, "."+graphiteBucket(b), d.Labels), strconv.FormatInt(b.Delta, 10))
+		}
+	}
+
+	return res
+}
+
+// buffer adds lines, dropping the oldest past BufferLines.  Must hold
+// g.mu.
+func (g *Graphite) buffer(lines []string) {
+	g.buf = append(g.buf, lines...)
+
+	if over := len(g.buf) - g.opts.BufferLines; over > 0 {
+		g.buf = append(g.buf[:0], g.buf[over:]...)
+		g.r.getOrMakeAndIncrCounter(graphiteDroppedName, "reporters", int64(over))
+	}
+}
+
+// flush connects if need be and writes the buffer.  Must hold g.mu.
+func (g *Graphite) flush(ctx context.Context) error {
+	if len(g.buf) == 0 {
+		return nil
+	}
+
+	if g.conn == nil {
+		if time.Now().Before(g.nextDial) {
+			return errGraphiteBackoff
+		}
+
+		conn, err := g.dial(ctx)
+		if err != nil {
+			g.failed()
+
+			return err
+		}
+
+		g.conn = conn
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = g.conn.SetWriteDeadline(deadline)
+	}
+
+	var sb strings.Builder
+
+	for _, l := range g.buf {
+		sb.WriteString(l)
+	}
+
+	// a part write may leave a line half sent; carbon drops it
+	if _, err := g.conn.Write([]byte(sb.String())); err != nil {
+		_ = g.conn.Close()
+		g.conn = nil
+		g.failed()
+
+		return err
+	}
+
+	g.buf = g.buf[:0]
+	g.backoff = g.opts.MinBackoff
+
+	return nil
+}
+
+// failed puts off the next dial, doubling the backoff.  Must hold
+// g.mu.
+func (g *Graphite) failed() {
+	g.nextDial = time.Now().Add(g.backoff)
+	g.backoff = min(2*g.backoff, g.opts.MaxBackoff)
+}
+
+// graphitePath is name with any suffix as another node, then extra,
+// then the labels as ;k=v tags.  Dots in the name are kept as the
+// hierarchy.
+func graphitePath(name string, suffix string, extra string, labels []Label) string {
+	path := graphiteNode(name, true)
+
+	if suffix != "" {
+		path += "." + graphiteNode(suffix, false)
+	}
+
+	path += extra
+
+	for _, l := range labels {
+		path += ";" + graphiteNode(l.Key, false) + "=" + graphiteNode(l.Value, false)
+	}
+
+	return path
+}
+
+// graphiteBucket is a bucket as one node, e.g. 001_1k_to_1_2k or
+// neg_001_1k_to_1_2k.
+func graphiteBucket(b BucketReport) string {
+	node := strings.ReplaceAll(b.Bucket, "-", "_to_")
+	if b.Upper < 0 {
+		node = "neg_" + node
+	}
+
+	return graphiteNode(node, false)
+}
+
+// graphiteNode keeps [A-Za-z0-9_-] (and dots if dots) and makes
+// anything else an underscore.
+func graphiteNode(s string, dots bool) string {
+	return strings.Map(func(c rune) rune {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '-':
+			return c
+		case c == '.' && dots:
+			return c
+		}
+
+		return '_'
+	}, s)
+}
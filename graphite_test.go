@@ -0,0 +1,148 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGraphite(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no TCP: %v", err)
+	}
+	defer ln.Close()
+
+	lines := make(chan string, 100)
+
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+	r.SetSuffixMode("hits", PerCaller)
+
+	g := r.NewGraphite(ln.Addr().String(), GraphiteOptions{Prefix: "web1."})
+	defer g.Close()
+
+	r.IncrDeltaSyncSuffix("hits", 3, "main.handler")
+	r.NewValue("temp").Set(21.5)
+	r.NewDistribution("lat").Mark(-1113)
+	logAndFlush(t, r)
+
+	want := map[string]bool{
+		"web1.hits.main_handler.total 3": false,
+		"web1.hits.main_handler.delta 3": false,
+		"web1.hits.total 3":              false,
+		"web1.temp 21.5":                 false,
+		"web1.lat.neg_001_1k_to_1_2k 1":  false,
+	}
+
+	timeout := time.After(5 * time.Second)
+
+	for missing := len(want); missing > 0; {
+		select {
+		case l := <-lines:
+			f := strings.Fields(l)
+			if len(f) != 3 {
+				t.Fatalf("Bad line %q", l)
+			}
+
+			if seen, ok := want[f[0]+" "+f[1]]; ok && !seen {
+				want[f[0]+" "+f[1]] = true
+				missing--
+			}
+		case <-timeout:
+			t.Fatalf("Lines missing %v", want)
+		}
+	}
+}
+
+func TestGraphiteBufferAndReconnect(t *testing.T) {
+	r := NewRegistry()
+	g := &Graphite{
+		r:       r,
+		opts:    GraphiteOptions{BufferLines: 3, MinBackoff: time.Millisecond, MaxBackoff: 4 * time.Millisecond},
+		backoff: time.Millisecond,
+	}
+
+	var conn net.Conn
+
+	g.dial = func(_ context.Context) (net.Conn, error) {
+		if conn == nil {
+			return nil, errors.New("down")
+		}
+
+		return conn, nil
+	}
+
+	rep := &Report{Time: time.Unix(1700000000, 0)}
+
+	for _, name := range []string{"a", "b"} {
+		rep.Values = append(rep.Values, ValReport{Name: name, Base: name, Value: 1})
+	}
+
+	if err := g.Report(context.Background(), rep); err == nil {
+		t.Fatalf("Expected an error while down")
+	}
+
+	// a retry of the same report isn't buffered again
+	time.Sleep(2 * time.Millisecond)
+	_ = g.Report(context.Background(), rep)
+
+	if len(g.buf) != 2 {
+		t.Errorf("Expected 2 lines buffered got %v", g.buf)
+	}
+
+	rep2 := &Report{Time: rep.Time, Values: []ValReport{{Name: "c", Base: "c"}, {Name: "d", Base: "d"}}}
+	_ = g.Report(context.Background(), rep2)
+
+	if len(g.buf) != 3 || !strings.HasPrefix(g.buf[0], "b ") {
+		t.Errorf("Expected the oldest line dropped got %v", g.buf)
+	}
+
+	if got := r.ReadSync(graphiteDroppedName); got != 1 {
+		t.Errorf("Expected 1 dropped got %d", got)
+	}
+
+	client, server := net.Pipe()
+	conn = client
+
+	go func() {
+		sc := bufio.NewScanner(server)
+		for sc.Scan() {
+		}
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+
+	if err := g.Report(context.Background(), rep2); err != nil {
+		t.Errorf("Expected to reconnect got %v", err)
+	}
+
+	if len(g.buf) != 0 {
+		t.Errorf("Expected the buffer written got %v", g.buf)
+	}
+}
+
+func TestGraphitePath(t *testing.T) {
+	if got := graphitePath("http.req", "pkg.(*T).Serve", ".total", []Label{L("code", "5 00")}); got != "http.req.pkg___T__Serve.total;code=5_00" {
+		t.Errorf("Got %s", got)
+	}
+}
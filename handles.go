@@ -0,0 +1,205 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this handles.go file has pre-resolved handles for hot paths; the
+// map lookups are done once when the handle is made and after that
+// an increment is a single atomic op on one of the counter's cells.
+// The handles use the same maps so LogCounters and the reporters see
+// them like any other.  A handle made before a Shutdown and
+// InitCounters keeps pointing at the old counter so make new ones
+// after a restart.
+
+import (
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a handle to a merged counter, see NewCounter.
+type Counter struct {
+	r    *Registry
+	name string
+	c    atomic.Pointer[counter]
+}
+
+// Value is a handle to a merged value, see NewValue.
+type Value struct {
+	r    *Registry
+	name string
+	v    atomic.Pointer[value]
+}
+
+// Distribution is a handle to a distribution, see NewDistribution.
+type Distribution struct {
+	r     *Registry
+	name  string
+	mu    sync.Mutex // serialises changing cache
+	cache atomic.Pointer[distCache]
+}
+
+// distCache is the buckets a Distribution handle has marked, each
+// with the smallest and largest value seen to land in it, so marking
+// a value between those skips deriveDistName.  That needs a
+// Resolution's buckets to be ranges, which the provided ones are;
+// the bounds in the bucket names aren't used as HighRes rounds.
+// Never changed once stored.
+type distCache struct {
+	gen     uint64         // the Registry's resolutionGen it is for
+	buckets []cachedBucket // sorted, they don't overlap
+}
+
+type cachedBucket struct {
+	lo float64
+	hi float64
+	c  *counter
+}
+
+// NewCounter returns a handle for the counter name, making it if
+// needed.  Handles are for hot paths so the counter is striped
+// straight away.
+func (r *Registry) NewCounter(name string) *Counter {
+	h := &Counter{r: r, name: name}
+	h.resolve()
+
+	return h
+}
+
+// resolve (re)makes the handle's counter.
+func (h *Counter) resolve() *counter {
+	c := h.r.getOrMakeCounter(h.r.countersByName, h.name, h.name, "", nil)
+	c.held.Store(true)
+	c.stripe()
+	h.c.Store(c)
+
+	return c
+}
+
+// counter is the handle's counter, made again if it was removed.
+func (h *Counter) counter() *counter {
+	if c := h.c.Load(); !c.removed.Load() {
+		return c
+	}
+
+	return h.resolve()
+}
+
+// Incr adds one to the counter.
+func (h *Counter) Incr() {
+	h.counter().add(1)
+}
+
+// Add adds i to the counter (negative values are fine).
+func (h *Counter) Add(i int64) {
+	h.counter().add(i)
+}
+
+// Read returns the counter's total.
+func (h *Counter) Read() int64 {
+	return h.counter().load()
+}
+
+// NewValue returns a handle for the value name, making it if needed.
+func (r *Registry) NewValue(name string) *Value {
+	h := &Value{r: r, name: name}
+	h.resolve()
+
+	return h
+}
+
+// resolve (re)makes the handle's value.
+func (h *Value) resolve() *value {
+	v := h.r.getOrMakeValue(h.r.valuesByName, h.name, h.name, "", nil)
+	v.held.Store(true)
+	h.v.Store(v)
+
+	return v
+}
+
+// value is the handle's value, made again if it was removed.
+func (h *Value) value() *value {
+	if v := h.v.Load(); !v.removed.Load() {
+		return v
+	}
+
+	return h.resolve()
+}
+
+// Set sets the value.
+func (h *Value) Set(f float64) {
+	h.value().set(f)
+}
+
+// Add adds delta to the value, see AddValue.
+func (h *Value) Add(delta float64) {
+	h.value().observe(delta, true)
+}
+
+// NewDistribution returns a handle for the distribution name; the
+// buckets are looked up once each and then cached in the handle.
+func (r *Registry) NewDistribution(name string) *Distribution {
+	return &Distribution{r: r, name: name}
+}
+
+// Mark marks the histogram bucket for value.
+func (h *Distribution) Mark(value float64) {
+	h.r.observeSample(h.name, value, "", nil)
+
+	gen := h.r.resolutionGen.Load()
+
+	if dc := h.cache.Load(); dc != nil && dc.gen == gen {
+		c := dc.find(value)
+		if c != nil && !c.removed.Load() {
+			c.add(1)
+
+			return
+		}
+
+		if c != nil { // a bucket was removed, start over
+			h.cache.CompareAndSwap(dc, nil)
+		}
+	}
+
+	derived := h.r.deriveDistName(h.name, value)
+	c := h.r.bucketCounter(h.name, derived)
+
+	if c.name == derived { // not the overflow counter
+		h.remember(gen, value, c)
+	}
+
+	c.add(1)
+}
+
+// find is the cached bucket whose seen range has value in it.
+func (dc *distCache) find(value float64) *counter {
+	i := sort.Search(len(dc.buckets), func(i int) bool { return dc.buckets[i].hi >= value })
+	if i < len(dc.buckets) && dc.buckets[i].lo <= value {
+		return dc.buckets[i].c
+	}
+
+	return nil
+}
+
+// remember widens c's seen range to take in value, adding c if it is
+// new and starting over if the Resolution has changed.
+func (h *Distribution) remember(gen uint64, value float64, c *counter) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var buckets []cachedBucket
+	if old := h.cache.Load(); old != nil && old.gen == gen {
+		buckets = slices.Clone(old.buckets)
+	}
+
+	i := slices.IndexFunc(buckets, func(b cachedBucket) bool { return b.c == c })
+	if i < 0 {
+		i = sort.Search(len(buckets), func(i int) bool { return buckets[i].lo > value })
+		buckets = slices.Insert(buckets, i, cachedBucket{value, value, c})
+	}
+
+	buckets[i].lo = min(buckets[i].lo, value)
+	buckets[i].hi = max(buckets[i].hi, value)
+
+	h.cache.Store(&distCache{gen: gen, buckets: buckets})
+}
@@ -0,0 +1,144 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"math"
+	"math/rand/v2"
+	"sync"
+	"testing"
+)
+
+func TestHandles(t *testing.T) {
+	r := NewRegistry()
+
+	c := r.NewCounter("handled")
+
+	var wg sync.WaitGroup
+
+	for range 10 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 1000 {
+				c.Incr()
+			}
+		}()
+	}
+
+	wg.Wait()
+	c.Add(5)
+	r.IncrSyncSuffix("handled", "test")
+
+	if got := r.ReadSync("handled"); got != 10006 {
+		t.Errorf("Expected 10006 shared with the name API, got %d", got)
+	}
+
+	if got := c.Read(); got != 10006 {
+		t.Errorf("Expected handle to read 10006, got %d", got)
+	}
+
+	v := r.NewValue("handled_val")
+	v.Set(2.5)
+
+	hv, _ := r.valuesByName.get("handled_val")
+	got := hv.data
+
+	if got != 2.5 {
+		t.Errorf("Expected 2.5, got %f", got)
+	}
+
+	d := r.NewDistribution("handled_dist")
+	d.Mark(1113)
+	d.Mark(1113)
+	d.Mark(0)
+
+	if got := r.ReadSync("handled_distg[001.1k-1.2k]"); got != 2 {
+		t.Errorf("Expected 2 in the bucket, got %d", got)
+	}
+
+	if got := r.ReadSync("handled_dist [zero]"); got != 1 {
+		t.Errorf("Expected 1 in the zero bucket, got %d", got)
+	}
+
+	r.LogCounters()
+}
+
+// TestDistributionCache checks the handle's cached buckets land every
+// value where deriveDistName would, bounds and a Resolution change
+// included.
+func TestDistributionCache(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	d := r.NewDistribution("hc")
+	values := []float64{0, 1, 1.05, 1.1, 1.15, 1.1, 999, 1000, 1001, 1113, -1113, -1100, -1150, 0.0021, 5e7, 1e16}
+
+	rnd := rand.New(rand.NewPCG(1, 2)) //nolint:gosec
+	for range 2000 {
+		values = append(values, math.Round(rnd.NormFloat64()*3000)/10)
+	}
+
+	mark := func() {
+		for _, v := range values {
+			d.Mark(v)
+		}
+	}
+
+	want := func() map[string]int64 {
+		res := map[string]int64{}
+		for _, v := range values {
+			res[r.deriveDistName("hc", v)]++
+		}
+
+		return res
+	}
+
+	check := func(times int64) {
+		t.Helper()
+
+		for name, n := range want() {
+			if got := r.ReadSync(name); got != n*times {
+				t.Errorf("Expected %d in %s got %d", n*times, name, got)
+			}
+		}
+	}
+
+	mark()
+	mark() // from the cache
+	check(2)
+
+	if dc := d.cache.Load(); dc == nil || len(dc.buckets) == 0 {
+		t.Errorf("Expected cached buckets")
+	}
+
+	r.SetResolution(LowRes)
+	r.ResetAll()
+	mark()
+	check(1)
+}
+
+func BenchmarkDistributionMark(b *testing.B) {
+	r := NewRegistry()
+	d := r.NewDistribution("num_of_marked_things")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		d.Mark(float64(i % 1000))
+	}
+}
+
+func BenchmarkHandleIncr(b *testing.B) {
+	InitCounters()
+
+	c := NewCounter("num_of_handle_things")
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.Incr()
+	}
+}
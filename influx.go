@@ -0,0 +1,284 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// this influx.go file writes each interval as InfluxDB line
+// protocol to an io.Writer, a file or an HTTP /write endpoint.
+
+// InfluxHTTPOptions configure writing to InfluxDB over HTTP; zero
+// fields get the defaults.
+type InfluxHTTPOptions struct {
+	URL        string       // e.g. http://localhost:8086/write?db=app
+	Token      string       // sent as Authorization: Token ..., if set
+	BatchLines int          // lines per request, default 5000
+	Gzip       bool         // gzip the request bodies
+	Client     *http.Client // default http.DefaultClient
+}
+
+// Influx is a Reporter writing line protocol.  Each counter is a
+// measurement with total, delta and rate fields; values have value
+// and delta, meta counters value, and distribution buckets are the
+// distribution's measurement with a bucket tag and the counter
+// fields.  A suffix is the tag suffix and labels are tags too.
+type Influx struct {
+	r      *Registry
+	mu     sync.Mutex
+	w      io.Writer
+	closer io.Closer
+	http   *InfluxHTTPOptions
+}
+
+// NewInflux adds a Reporter writing line protocol to w.
+func (r *Registry) NewInflux(w io.Writer) *Influx {
+	x := &Influx{r: r, w: w}
+	r.AddReporter(x)
+
+	return x
+}
+
+// NewInfluxFile adds a Reporter appending line protocol to the file
+// at path.
+func (r *Registry) NewInfluxFile(path string) (*Influx, error) {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644) //nolint:mnd
+	if err != nil {
+		return nil, err
+	}
+
+	x := &Influx{r: r, w: f, closer: f}
+	r.AddReporter(x)
+
+	return x, nil
+}
+
+// NewInfluxHTTP adds a Reporter posting line protocol to an InfluxDB
+// /write endpoint in batches.  A retried report is sent again in
+// full; InfluxDB overwrites the points so that is harmless.
+func (r *Registry) NewInfluxHTTP(opts InfluxHTTPOptions) *Influx {
+	if opts.BatchLines <= 0 {
+		opts.BatchLines = 5000 //nolint:mnd
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	x := &Influx{r: r, http: &opts}
+	r.AddReporter(x)
+
+	return x
+}
+
+// Close removes the Influx from the registry and closes its file if
+// it has one.
+func (x *Influx) Close() error {
+	x.r.RemoveReporter(x)
+
+	if x.closer != nil {
+		return x.closer.Close()
+	}
+
+	return nil
+}
+
+// Report writes rep as line protocol.
+func (x *Influx) Report(ctx context.Context, rep *Report) error {
+	lines := influxLines(rep)
+
+	if x.http != nil {
+		return x.post(ctx, lines)
+	}
+
+	x.mu.Lock()
+	defer x.mu.Unlock()
+
+	_, err := io.WriteString(x.w, strings.Join(lines, ""))
+
+	return err
+}
+
+// errInfluxStatus is a failed /write response.
+var errInfluxStatus = errors.New("influx: request failed")
+
+// post sends the lines in batches.
+func (x *Influx) post(ctx context.Context, lines []string) error {
+	for len(lines) > 0 {
+		n := min(len(lines), x.http.BatchLines)
+
+		if err := x.postBatch(ctx, lines[:n]); err != nil {
+			return err
+		}
+
+		lines = lines[n:]
+	}
+
+	return nil
+}
+
+func (x *Influx) postBatch(ctx context.Context, lines []string) error {
+	var body bytes.Buffer
+
+	if x.http.Gzip {
+		zw := gzip.NewWriter(&body)
+
+		for _, l := range lines {
+			_, _ = zw.Write([]byte(l))
+		}
+
+		if err := zw.Close(); err != nil {
+			return err
+		}
+	} else {
+		for _, l := range lines {
+			body.WriteString(l)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, x.http.URL, &body)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+
+	if x.http.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	if x.http.Token != "" {
+		req.Header.Set("Authorization", "Token "+x.http.Token)
+	}
+
+	resp, err := x.http.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 { //nolint:mnd
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) //nolint:mnd
+
+		return fmt.Errorf("%w: %s: %s", errInfluxStatus, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	return nil
+}
+
+// influxLines renders rep, one string per line with its newline.
+func influxLines(rep *Report) []string {
+	ts := " " + strconv.FormatInt(rep.Time.UnixNano(), 10) + "\n"
+	res := []string{}
+
+	counterFields := func(m MetricReport) string {
+		return "total=" + strconv.FormatInt(m.Total, 10) + "i" +
+			",delta=" + strconv.FormatInt(m.Delta, 10) + "i" +
+			",rate=" + influxFloat(m.Rate)
+	}
+
+	for _, c := range rep.Counters {
+		res = append(res, influxKey(c.Base, c.Suffix, c.Labels)+" "+counterFields(c)+ts)
+	}
+
+	for _, v := range rep.Values {
+		res = append(res, influxKey(v.Base, v.Suffix, v.Labels)+
+			" value="+influxFloat(v.Value)+",delta="+influxFloat(v.Delta)+ts)
+	}
+
+	for _, m := range rep.Meta {
+		base, suffix, _ := strings.Cut(m.Name, "/")
+		res = append(res, influxKey(base, suffix, nil)+
+			" value="+influxFloat(m.Total)+",delta="+influxFloat(m.Delta)+ts)
+	}
+
+	for _, d := range rep.Distributions {
+		for _, b := range d.Buckets {
+			key := influxKey(d.Name, d.Suffix, append([]Label{L("bucket", influxBucket(b))}, d.Labels...))
+			res = append(res, key+" "+counterFields(b.MetricReport)+ts)
+		}
+	}
+
+	return res
+}
+
+// influxKey is the measurement and tags, sorted by key as InfluxDB
+// prefers.
+func influxKey(name string, suffix string, labels []Label) string {
+	tags := append([]Label(nil), labels...)
+
+	if suffix != "" {
+		tags = append(tags, L("suffix", suffix))
+	}
+
+	var sb strings.Builder
+
+	sb.WriteString(influxEscape(name, ", "))
+
+	for _, l := range sortedLabels(tags) {
+		if l.Value == "" { // empty tag values aren't allowed
+			continue
+		}
+
+		sb.WriteString("," + influxEscape(l.Key, ",= ") + "=" + influxEscape(l.Value, ",= "))
+	}
+
+	return sb.String()
+}
+
+// influxBucket is the bucket with a - sign for negative ones.
+func influxBucket(b BucketReport) string {
+	if b.Upper < 0 {
+		return "-" + b.Bucket
+	}
+
+	return b.Bucket
+}
+
+// influxEscape backslashes the characters special in this position;
+// newlines can't be escaped so become underscores.
+func influxEscape(s string, special string) string {
+	if !strings.ContainsAny(s, special+"\n") {
+		return s
+	}
+
+	var sb strings.Builder
+
+	for _, c := range s {
+		switch {
+		case c == '\n':
+			sb.WriteByte('_')
+		case strings.ContainsRune(special, c):
+			sb.WriteByte('\\')
+			sb.WriteRune(c)
+		default:
+			sb.WriteRune(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// influxFloat formats f as a field; NaN and Inf aren't allowed so are
+// written as 0.
+func influxFloat(f float64) string {
+	s := formatFloat(f)
+	if strings.ContainsAny(s, "NI") {
+		return "0"
+	}
+
+	return s
+}
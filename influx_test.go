@@ -0,0 +1,151 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInfluxLines(t *testing.T) {
+	rep := &Report{
+		Time: time.Unix(1700000000, 5),
+		Counters: []MetricReport{
+			{Name: "req/main", Base: "req", Suffix: "main", Total: 10, Delta: 4, Rate: 0.5},
+			{Name: "req{code=5 00}", Base: "req", Labels: []Label{L("code", "5 00")}, Total: 1, Delta: 1},
+		},
+		Values: []ValReport{{Name: "temp, room", Base: "temp, room", Value: 21.5, Delta: -0.5}},
+		Distributions: []DistributionReport{{
+			Name: "lat",
+			Buckets: []BucketReport{{
+				MetricReport: MetricReport{Name: "lat-g[001.1k-1.2k]", Total: 2, Delta: 1},
+				Bucket:       "001.1k-1.2k",
+				Lower:        -1200,
+				Upper:        -1100,
+			}},
+		}},
+	}
+
+	got := strings.Join(influxLines(rep), "")
+	want := "req,suffix=main total=10i,delta=4i,rate=0.5 1700000000000000005\n" +
+		`req,code=5\ 00 total=1i,delta=1i,rate=0 1700000000000000005` + "\n" +
+		`temp\,\ room value=21.5,delta=-0.5 1700000000000000005` + "\n" +
+		"lat,bucket=-001.1k-1.2k total=2i,delta=1i,rate=0 1700000000000000005\n"
+
+	if got != want {
+		t.Errorf("Expected\n%s\ngot\n%s", want, got)
+	}
+}
+
+func TestInfluxWriterAndFile(t *testing.T) {
+	r := NewRegistry()
+
+	var buf bytes.Buffer
+
+	x := r.NewInflux(&buf)
+	path := filepath.Join(t.TempDir(), "metrics.lp")
+
+	f, err := r.NewInfluxFile(path)
+	if err != nil {
+		t.Fatalf("NewInfluxFile: %v", err)
+	}
+
+	r.IncrDeltaSyncSuffix("writes", 2, "a")
+	logAndFlush(t, r)
+
+	if err := f.Close(); err != nil {
+		t.Errorf("Close: %v", err)
+	}
+
+	_ = x.Close()
+
+	data, _ := os.ReadFile(path)
+
+	for _, out := range []string{buf.String(), string(data)} {
+		if !strings.Contains(out, "writes total=2i,delta=2i,rate=") {
+			t.Errorf("Expected the writes counter in\n%s", out)
+		}
+	}
+}
+
+func TestInfluxHTTP(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		bodies  []string
+		headers []http.Header
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var rd io.Reader = req.Body
+
+		if req.Header.Get("Content-Encoding") == "gzip" {
+			zr, err := gzip.NewReader(req.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+
+				return
+			}
+
+			rd = zr
+		}
+
+		body, _ := io.ReadAll(rd)
+
+		mu.Lock()
+		bodies = append(bodies, string(body))
+		headers = append(headers, req.Header)
+		mu.Unlock()
+
+		if req.URL.Path != "/write" {
+			w.WriteHeader(http.StatusNotFound)
+
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	r := NewRegistry()
+	x := r.NewInfluxHTTP(InfluxHTTPOptions{URL: srv.URL + "/write?db=test", BatchLines: 2, Gzip: true, Token: "tok"})
+
+	defer x.Close()
+
+	rep := &Report{Time: time.Now()}
+	for _, s := range []string{"a", "b", "c"} {
+		rep.Counters = append(rep.Counters, MetricReport{Name: "posted_" + s, Base: "posted_" + s, Total: 1, Delta: 1})
+	}
+
+	if err := x.Report(context.Background(), rep); err != nil {
+		t.Fatalf("Report: %v", err)
+	}
+
+	bad := r.NewInfluxHTTP(InfluxHTTPOptions{URL: srv.URL + "/nope"})
+	defer bad.Close()
+
+	if err := bad.Report(context.Background(), rep); !errors.Is(err, errInfluxStatus) || !strings.Contains(err.Error(), "404") {
+		t.Errorf("Expected a 404 error got %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	if len(bodies) != 3 || strings.Count(bodies[0], "\n") != 2 || strings.Count(bodies[1], "\n") != 1 {
+		t.Errorf("Expected batches of 2 and 1 (then the bad one) got %q", bodies)
+	}
+
+	if headers[0].Get("Authorization") != "Token tok" {
+		t.Errorf("Expected the token sent")
+	}
+}
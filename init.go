@@ -4,9 +4,11 @@
 package counters
 
 import (
+	"context"
 	"log"
-	"sort"
+	"slices"
 	"strconv"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -17,10 +19,21 @@ import (
 const numChannels = 10
 
 // MetricReport is the minutes change in
-// the named metric.
+// the named metric.  Labels are set for counters made with
+// IncrLabels etc. and are also part of the Name.
 type MetricReport struct {
-	Name  string
-	Delta int64
+	Name     string
+	Base     string // Name without the suffix or labels
+	Suffix   string // only for PerCaller breakdowns
+	Total    int64
+	Delta    int64
+	Labels   []Label
+	Interval time.Duration // the time actually covered by Delta
+	Rollups  []Rollup      // one per SetRollups window
+	Rate     float64       // per second over Interval
+	Rate1    float64       // per second, 1 minute moving average
+	Rate5    float64       // per second, 5 minute moving average
+	Rate15   float64       // per second, 15 minute moving average
 }
 
 // MetricReporter is a function callback that can be registered
@@ -28,10 +41,20 @@ type MetricReport struct {
 type MetricReporter func(metrics []MetricReport) // callback used below in SetMetricReporter
 
 // ValReport is the minutes change in
-// the named metric.
+// the named metric.  Value is the interval's value aggregated per
+// Mode (see SetValueMode) and Delta its change from the last
+// interval.  Labels are set for values made with SetLabels and are
+// also part of the Name.
 type ValReport struct {
-	Name  string
-	Delta float64
+	Name     string
+	Base     string // Name without the suffix or labels
+	Suffix   string // only for PerCaller breakdowns
+	Delta    float64
+	Labels   []Label
+	Value    float64
+	Mode     ValueMode
+	Interval time.Duration // the time actually covered by Delta
+	Rollups  []Rollup      // one per SetRollups window
 }
 
 // ValReporter is a function callback that can be registered
@@ -46,419 +69,590 @@ type metaCounter struct {
 }
 
 type counter struct {
-	oldData int64
-	data    int64
+	base        cell                   // the one cell until contended, see storage.go
+	stripes     atomic.Pointer[[]cell] // nil until striped
+	oldData     int64
+	idle        int     // intervals in a row with no change, see SetIdleExpiry
+	rollup      rollups // guarded by ctxLock
+	rates       ewma    // guarded by ctxLock
+	history     deltas  // guarded by ctxLock
+	exemplar    atomic.Pointer[Exemplar]
+	held        atomic.Bool // a handle uses it so it doesn't idle out
+	removed     atomic.Bool // handles make it again, see Remove
+	admitted    bool        // counted by the cardinality limits
+	name        string      // without the suffix or labels
+	suffix      string      // only for PerCaller breakdowns
+	labels      []Label     // sorted, only for IncrLabels etc. counters
+	firstSeen   time.Time
+	seenTotal   int64     // total when lastUpdated was last checked
+	lastUpdated time.Time // to the granularity of LogCounters/Snapshot
+}
+
+func newCounter() *counter {
+	return &counter{}
+}
+
+// makeCounter returns a new counter for name and its suffix or labels.
+func makeCounter(name string, suffix string, labels []Label) *counter {
+	return &counter{
+		name:      name,
+		suffix:    suffix,
+		labels:    labels,
+		firstSeen: time.Now(),
+	}
+}
+
+// getOrMakeCounter returns the counter at key in m, making it for
+// name and its suffix or labels if it isn't there.
+func (r *Registry) getOrMakeCounter(m *shardedMap[*counter], key string, name string, suffix string, labels []Label) *counter {
+	if c, ok := m.get(key); ok {
+		return c
+	}
+
+	return r.counterFor(m, key, func() *counter { return makeCounter(name, suffix, labels) })
+}
+
+// noteChange moves lastUpdated along if total has changed since the
+// last look.  Must hold ctxLock for writing.
+func (c *counter) noteChange(total int64, now time.Time) {
+	if total != c.seenTotal {
+		c.seenTotal = total
+		c.lastUpdated = now
+	}
 }
 
 type counterMsg struct {
 	name   string
 	suffix string
 	i      int64
+	labels []Label
+	dist   string // for a bucket, the distribution it is of
 }
 
 type value struct {
-	oldData float64
-	data    float64
-	N       float64
+	mu          sync.Mutex // guards the data, Set is not atomic
+	name        string
+	suffix      string
+	firstSeen   time.Time
+	lastUpdated time.Time
+	mode        ValueMode
+	oldData     float64 // last interval's reported value
+	data        float64 // last value set (or running total for AddValue)
+	N           float64 // observations this interval
+	sum         float64
+	min         float64
+	max         float64
+	idle        int
+	labels      []Label
+	rollup      rollups // guarded by ctxLock not mu
+	history     deltas  // guarded by ctxLock not mu
+	held        atomic.Bool
+	removed     atomic.Bool
+	admitted    bool
 }
 
 type valueMsg struct {
 	name   string
 	suffix string
 	v      float64
+	add    bool // AddValue rather than Set
+	labels []Label
 }
 
-type ctx struct {
-	valuesByName   map[string]*value // key present, nil value means check values
-	values         map[string]*value
-	countersByName map[string]*counter // key present, nil value means check counter
-	counters       map[string]*counter
-	metaCtrs       map[string]*metaCounter
-	maxLen         int // length of longest metric
-	logCb          MetricReporter
-	valCb          ValReporter
-	ctxLock        sync.RWMutex
-	startTime      time.Time
-	started        bool
-	finished       chan bool
-	c              []chan counterMsg
-	v              []chan valueMsg
-	fmtString      string
-	fmtStringStr   string
-	fmtStringF64   string
-	timeSleep      float64
+// Registry is an independent set of counters, values and meta
+// counters with its own channels, reading go routines and reporters.
+// The package level functions all work on a default Registry; make
+// more with NewRegistry to keep e.g. per-tenant or per-test counts
+// apart.
+type Registry struct {
+	valuesByName      *shardedMap[*value]   // merged by name
+	values            *shardedMap[*value]   // PerCaller name/suffix breakdown
+	countersByName    *shardedMap[*counter] // merged by name
+	counters          *shardedMap[*counter] // PerCaller name/suffix breakdown
+	suffixModes       atomic.Pointer[map[string]SuffixMode]
+	valueModes        atomic.Pointer[map[string]ValueMode]
+	defaultSuffixMode atomic.Int32 // a SuffixMode
+	metaCtrs          map[string]*metaCounter
+	maxLen            int             // length of longest metric
+	logCb             *metricReporter // the SetMetricReporter one
+	valCb             *valReporter    // the SetValReporter one
+	reporters         []*reporterRunner
+	ctxLock           sync.RWMutex
+	startTime         time.Time
+	started           bool
+	finished          chan struct{} // closed by Shutdown to stop the go routines
+	shutdownDone      chan struct{} // closed once a Shutdown has completed
+	accepting         atomic.Bool   // false stops the async API queueing
+	wg                sync.WaitGroup
+	c                 []chan counterMsg
+	v                 []chan valueMsg
+	fmtString         string
+	fmtStringStr      string
+	fmtStringF64      string
+	interval          atomic.Int64  // a time.Duration, see SetLogInterval
+	intervalChanged   chan struct{} // wakes minuteGoRoutine on SetLogInterval
+	lastLog           time.Time     // when the last interval started
+	numCalled         uint32
+	overflowPolicy    atomic.Int32 // an OverflowPolicy
+	overflowTimeout   atomic.Int64 // a time.Duration
+	counterDrops      [numChannels]int64
+	valueDrops        [numChannels]int64
+	resolution        atomic.Value  // a Resolution
+	resolutionGen     atomic.Uint64 // bumped by SetResolution
+	limitMu           sync.Mutex
+	limits            cardinality
+	idleExpiry        int // intervals, 0 is never
+	buckets           *shardedMap[*bucketInfo]
+	rollupWindows     []time.Duration // see SetRollups
+	units             map[string]string
+	observers         atomic.Pointer[[]SampleObserver] // copy on write
 }
 
-var theCtx = ctx{}
+// theCtx is the default Registry used by the package level API.
+var theCtx = &Registry{}
+
+// NewRegistry returns a new Registry with its go routines already
+// started; it shares nothing with the default one.
+func NewRegistry() *Registry {
+	r := &Registry{}
+	r.InitCounters()
+
+	return r
+}
 
 // LogCounters prints out the counters.  It is called internally
 // each minute but can be called externally e.g. at process end.
-func LogCounters() { // nolint:cyclop
-	theCtx.ctxLock.Lock()
-	updateMaxLen(nil, nil)
+func (r *Registry) LogCounters() {
+	r.countDrops()
 
-	theCtx.fmtString = "%-" + strconv.Itoa(theCtx.maxLen+12) + "s  %20d %20d\n"    //nolint:mnd
-	theCtx.fmtStringStr = "%-" + strconv.Itoa(theCtx.maxLen+12) + "s  %20s %20s\n" //nolint:mnd
-	theCtx.fmtStringF64 = "%-" + strconv.Itoa(theCtx.maxLen+12) + "s  %20f %20f\n" //nolint:mnd
-	fmtStringStr := theCtx.fmtStringStr
+	ctrs := sortedEntries(r.countersByName, r.counters)
+	vals := sortedEntries(r.valuesByName, r.values)
 
-	theCtx.ctxLock.Unlock()
+	r.ctxLock.Lock()
+	r.updateMaxLen(ctrs, vals)
 
-	log.Printf(fmtStringStr, "--------------------------", time.Now(), "")
-	log.Printf(fmtStringStr, "Uptime", time.Since(theCtx.startTime), "")
+	now := time.Now()
+	elapsed := now.Sub(r.lastLog)
+	r.lastLog = now
 
-	theCtx.ctxLock.Lock()
+	r.fmtString = "%-" + strconv.Itoa(r.maxLen+12) + "s  %20d %20d\n"    //nolint:mnd
+	r.fmtStringStr = "%-" + strconv.Itoa(r.maxLen+12) + "s  %20s %20s\n" //nolint:mnd
+	r.fmtStringF64 = "%-" + strconv.Itoa(r.maxLen+12) + "s  %20f %20f\n" //nolint:mnd
+	fmtStringStr := r.fmtStringStr
+	rollupHeader := r.rollupHeader()
 
-	i := 0
+	r.ctxLock.Unlock()
 
-	// do meta counters first before oldData is updated
-	mctrNames := make([]string, len(theCtx.metaCtrs))
+	log.Printf(fmtStringStr, "--------------------------", time.Now(), "")
+	log.Printf(fmtStringStr, "Uptime", time.Since(r.startTime), "")
+	log.Printf(fmtStringStr, "Interval", elapsed, "")
 
-	for k := range theCtx.metaCtrs { // cool scope is only in loop
-		mctrNames[i] = theCtx.metaCtrs[k].name
-		i++
-	}
+	log.Printf(strings.TrimSuffix(fmtStringStr, "\n")+"%s%s\n", "", "Total", "Delta", rateHeader, rollupHeader)
 
-	log.Printf(theCtx.fmtStringStr, "---M-E-T-A- -C-O-U-N-T----", time.Now(), "")
-	sort.Strings(mctrNames)
+	r.ctxLock.Lock()
 
-	for k := range mctrNames {
-		logMetaCounter(theCtx.metaCtrs[mctrNames[k]], theCtx.counters)
-	}
+	// do meta counters first before oldData is updated
+	log.Printf(r.fmtStringStr, "---M-E-T-A- -C-O-U-N-T----", time.Now(), "")
 
-	// then the counters
-	ctrNames := make([]string, len(theCtx.counters)+len(theCtx.countersByName))
-	valNames := make([]string, len(theCtx.values)+len(theCtx.valuesByName))
-	cbData := make([]MetricReport, len(theCtx.counters)+len(theCtx.countersByName)) // for CB
-	cbVal := make([]ValReport, len(theCtx.values)+len(theCtx.valuesByName))         // for CB
+	report := len(r.reporters) > 0
+	rep := &Report{Time: now, Start: r.startTime, Interval: elapsed}
 
-	updateMaxLen(&ctrNames, &valNames)
-	sort.Strings(valNames)
+	for _, name := range r.metaNames() {
+		lines := r.metaLines(r.metaCtrs[name])
+		r.logMetaLines(lines)
 
-	for k := range valNames {
-		v, ok := theCtx.valuesByName[valNames[k]]
-		if !ok || v == nil {
-			v = theCtx.values[valNames[k]]
+		if report {
+			rep.Meta = append(rep.Meta, lines...)
 		}
+	}
 
-		if theCtx.valCb != nil {
-			cbVal[k].Name = valNames[k]
-			cbVal[k].Delta = v.data - v.oldData
-		}
+	// then the values and counters
+	dists := bucketGroups[BucketReport]{}
+	expiredVals, expiredCtrs := []string{}, []string{}
 
-		logValue(valNames[k], v)
+	for _, e := range vals {
+		v := e.v
 
-		newV := v
-		newV.oldData = newV.data // have to update old data
-	}
+		v.mu.Lock()
+		observed := v.N > 0 // idle is not set at all, not set to the same
+		data, oldData, mode := v.interval(), v.oldData, v.mode
+		v.oldData = data // have to update old data
+		v.mu.Unlock()
 
-	sort.Strings(ctrNames)
+		if r.isIdle(&v.idle, !observed) && !v.held.Load() {
+			expiredVals = append(expiredVals, e.key)
 
-	for k := range ctrNames {
-		v, ok := theCtx.countersByName[ctrNames[k]]
-		if !ok || v == nil {
-			v = theCtx.counters[ctrNames[k]]
+			continue
 		}
 
-		data := atomic.LoadInt64(&v.data)
-
-		if theCtx.logCb != nil {
-			cbData[k].Name = ctrNames[k]
-			cbData[k].Delta = data - v.oldData
+		rus := r.rollupFor(&v.rollup, now, data, mode)
+		v.history.add(data - oldData)
+
+		if report {
+			rep.Values = append(rep.Values, ValReport{
+				Name:     e.key,
+				Base:     v.name,
+				Suffix:   v.suffix,
+				Delta:    data - oldData,
+				Labels:   v.labels,
+				Value:    data,
+				Mode:     mode,
+				Interval: elapsed,
+				Rollups:  rus,
+			})
 		}
 
-		logCounter(ctrNames[k], v, data)
-
-		newC := v
-		newC.oldData = data // have to update old data
+		r.logValue(e.key, data, oldData, mode, rus)
 	}
 
-	logCb := theCtx.logCb
-	valCb := theCtx.valCb
+	for _, e := range ctrs {
+		v := e.v
+		data := v.load()
+		v.noteChange(data, now)
 
-	theCtx.ctxLock.Unlock()
+		if r.isIdle(&v.idle, data == v.oldData) && !v.held.Load() {
+			expiredCtrs = append(expiredCtrs, e.key)
 
-	if logCb != nil {
-		logCb(cbData)
-	}
-
-	if valCb != nil {
-		valCb(cbVal)
-	}
-}
+			continue
+		}
 
-// updateMaxLen updates the max len for formatting for both vals and ctrs.
-func updateMaxLen(ctrNames *[]string, valNames *[]string) { //nolint:cyclop
-	maxLen := 0
-	i := 0
+		rus := r.rollupFor(&v.rollup, now, float64(data-v.oldData), ValueSum)
+		rate := v.rates.update(data-v.oldData, elapsed)
+		v.history.add(float64(data - v.oldData))
+
+		if report {
+			m := MetricReport{
+				Name:     e.key,
+				Base:     v.name,
+				Suffix:   v.suffix,
+				Total:    data,
+				Delta:    data - v.oldData,
+				Labels:   v.labels,
+				Interval: elapsed,
+				Rollups:  rus,
+				Rate:     rate,
+				Rate1:    v.rates.rate1,
+				Rate5:    v.rates.rate5,
+				Rate15:   v.rates.rate15,
+			}
 
-	for k := range theCtx.counters {
-		if len(k) > maxLen {
-			maxLen = len(k)
+			if info, ok := r.buckets.get(v.name); ok {
+				dists.add(info, v, BucketReport{
+					MetricReport: m, Bucket: info.bucket, Lower: info.lower, Upper: info.upper, Known: info.ok,
+				})
+			} else {
+				rep.Counters = append(rep.Counters, m)
+			}
 		}
 
-		if ctrNames != nil {
-			(*ctrNames)[i] = k
-		}
+		r.logCounter(e.key, v, data, rate, rus)
 
-		i++
+		v.oldData = data // have to update old data
 	}
 
-	for k := range theCtx.countersByName {
-		if len(k) > maxLen {
-			maxLen = len(k)
-		}
-
-		if ctrNames != nil {
-			(*ctrNames)[i] = k
-		}
+	for _, k := range expiredVals {
+		r.removeValueKey(k)
+	}
 
-		i++
+	for _, k := range expiredCtrs {
+		r.removeCounterKey(k)
 	}
 
-	i = 0
+	for _, bg := range dists.sorted(func(b BucketReport) float64 { return b.Lower }) {
+		rep.Distributions = append(rep.Distributions, DistributionReport{
+			Name:    bg.dist,
+			Suffix:  bg.suffix,
+			Labels:  bg.labels,
+			Buckets: bg.buckets,
+		})
+	}
 
-	for k := range theCtx.values {
-		if len(k) > maxLen {
-			maxLen = len(k)
-		}
+	reporters := slices.Clone(r.reporters)
 
-		if valNames != nil {
-			(*valNames)[i] = k
-		}
+	r.ctxLock.Unlock()
 
-		i++
+	if report {
+		r.sendReport(reporters, rep)
 	}
+}
 
-	for k := range theCtx.valuesByName {
-		if len(k) > maxLen {
-			maxLen = len(k)
-		}
+// updateMaxLen updates the max len for formatting for both vals and ctrs.
+func (r *Registry) updateMaxLen(ctrs []mapEntry[*counter], vals []mapEntry[*value]) {
+	maxLen := 0
 
-		if valNames != nil {
-			(*valNames)[i] = k
-		}
+	for _, e := range ctrs {
+		maxLen = max(maxLen, len(e.key))
+	}
 
-		i++
+	for _, e := range vals {
+		maxLen = max(maxLen, len(e.key))
 	}
 
-	theCtx.maxLen = maxLen
+	r.maxLen = maxLen
 }
 
-func initCtx() {
-	theCtx.c = make([]chan counterMsg, numChannels)
+func (r *Registry) initCtx() {
+	r.c = make([]chan counterMsg, numChannels)
 
 	for i := range numChannels {
-		theCtx.c[i] = make(chan counterMsg, 100000) //nolint:mnd
+		r.c[i] = make(chan counterMsg, 100000) //nolint:mnd
 	}
 
-	theCtx.v = make([]chan valueMsg, numChannels)
+	r.v = make([]chan valueMsg, numChannels)
 
 	for i := range numChannels {
-		theCtx.v[i] = make(chan valueMsg, 100000) //nolint:mnd
+		r.v[i] = make(chan valueMsg, 100000) //nolint:mnd
 	}
 
-	theCtx.finished = make(chan bool, 1)
-	theCtx.counters = make(map[string]*counter)
-	theCtx.countersByName = make(map[string]*counter)
-	theCtx.values = make(map[string]*value)
-	theCtx.valuesByName = make(map[string]*value)
-	theCtx.metaCtrs = make(map[string]*metaCounter)
-	theCtx.started = true
-	theCtx.startTime = time.Now()
-}
-
-func minuteGoRoutine() {
-	theCtx.ctxLock.Lock()
-
-	if theCtx.timeSleep == 0 {
-		theCtx.timeSleep = 60.0
+	r.finished = make(chan struct{})
+	if r.counters == nil {
+		r.counters = newShardedMap[*counter]()
+		r.countersByName = newShardedMap[*counter]()
+		r.values = newShardedMap[*value]()
+		r.valuesByName = newShardedMap[*value]()
+		r.buckets = newShardedMap[*bucketInfo]()
+	} else { // a restart after Shutdown
+		r.counters.reset()
+		r.countersByName.reset()
+		r.values.reset()
+		r.valuesByName.reset()
+		r.buckets.reset()
 	}
 
-	timeSleep := theCtx.timeSleep
-	theCtx.ctxLock.Unlock()
-
-	for {
-		n := time.Now()
-
-		time.Sleep(time.Second * (time.Duration(timeSleep) - time.Duration(int64(time.Since(n)/time.Second))))
-		checkRuntime()
-		LogCounters()
+	r.limitMu.Lock()
+	r.limits.n = 0
+	clear(r.limits.byPrefix)
+	r.limitMu.Unlock()
+	r.metaCtrs = make(map[string]*metaCounter)
+	if r.intervalChanged == nil {
+		r.intervalChanged = make(chan struct{}, 1)
 	}
+
+	r.started = true
+	r.startTime = time.Now()
+	r.lastLog = r.startTime
 }
 
-func readingValGoRoutine(index int) {
+func (r *Registry) readingValGoRoutine(v chan valueMsg, finished chan struct{}) {
+	defer r.wg.Done()
+
 	for {
 		select {
 		// no default because this should block
-		case <-theCtx.finished:
-			return
-		case vm := <-theCtx.v[index]:
-			getOrMakeAndSetValue(vm.name, vm.suffix, vm.v)
+		case <-finished:
+			// drain whatever was queued before Shutdown
+			for {
+				select {
+				case vm := <-v:
+					r.applyValue(vm)
+				default:
+					return
+				}
+			}
+		case vm := <-v:
+			r.applyValue(vm)
 		}
 	}
 }
 
-// getOrMakeCounter checks the 2 hashes and increments the appropriate place.
-func getOrMakeAndIncrCounter(name string, suffix string, i int64) {
-	nameOnly := true // true means its in countersByName
-	key := ""
-
-	theCtx.ctxLock.RLock()
-
-	c, ok := theCtx.countersByName[name]
-
-	theCtx.ctxLock.RUnlock()
-
-	if ok && c == nil {
-		theCtx.ctxLock.RLock()
-
-		fullName := name + "/" + suffix
-		nameOnly = false
-		key = fullName
-		c, ok = theCtx.countersByName[fullName]
+// getOrMakeAndIncrCounter increments the merged counter for name
+// and, if name is PerCaller, the name/suffix one too, making them as
+// needed.
+func (r *Registry) getOrMakeAndIncrCounter(name string, suffix string, i int64) {
+	r.getOrMakeCounter(r.countersByName, name, name, "", nil).add(i)
 
-		theCtx.ctxLock.RUnlock()
-	} else {
-		key = name
+	if key := r.suffixKey(name, suffix); key != "" {
+		r.getOrMakeCounter(r.counters, key, name, suffix, nil).add(i)
 	}
+}
 
-	if !ok { // nolint:nestif
-		theCtx.ctxLock.Lock()
-
-		// another routine might have beat us to setting the counter, check again
-		if nameOnly {
-			c, ok = theCtx.countersByName[key]
-		} else {
-			c, ok = theCtx.counters[key]
-		}
-
-		if !ok {
-			c = &counter{}
-			c.data = i
+// incrBucket increments the bucket counter derived of distribution
+// name and, if name (not derived) is PerCaller, its derived/suffix
+// breakdown too.
+func (r *Registry) incrBucket(name string, derived string, suffix string, i int64) {
+	r.bucketCounter(name, derived).add(i)
 
-			if nameOnly {
-				theCtx.countersByName[key] = c
-			} else {
-				theCtx.counters[key] = c
-			}
-		} else {
-			atomic.AddInt64(&c.data, i)
-		}
-
-		theCtx.ctxLock.Unlock()
-	} else {
-		atomic.AddInt64(&c.data, i)
+	if r.suffixKey(name, suffix) != "" {
+		r.getOrMakeCounter(r.counters, derived+"/"+suffix, derived, suffix, nil).add(i)
 	}
 }
 
-// getOrMakeValue returns a counter struct.
-func getOrMakeAndSetValue(name string, suffix string, v float64) {
-	nameOnly := true
-	key := ""
-
-	theCtx.ctxLock.RLock()
-
-	c, ok := theCtx.valuesByName[name]
+// getOrMakeAndSetValue sets the merged value for name and, if name is
+// PerCaller, the name/suffix one too, making them as needed.
+func (r *Registry) getOrMakeAndSetValue(name string, suffix string, v float64) {
+	r.getOrMakeAndObserveValue(name, suffix, v, false)
+}
 
-	theCtx.ctxLock.RUnlock()
+// getOrMakeAndObserveValue sets (or if add, adds to) the merged value
+// for name and, if name is PerCaller, the name/suffix one too.
+func (r *Registry) getOrMakeAndObserveValue(name string, suffix string, v float64, add bool) {
+	r.getOrMakeValue(r.valuesByName, name, name, "", nil).observe(v, add)
 
-	if ok && c == nil {
-		theCtx.ctxLock.RLock()
-		nameOnly = false
-		fullName := name + "/" + suffix // will malloc
-		key = fullName
-		c, ok = theCtx.valuesByName[fullName]
-		theCtx.ctxLock.RUnlock()
-	} else {
-		key = name
+	if key := r.suffixKey(name, suffix); key != "" {
+		r.getOrMakeValue(r.values, key, name, suffix, nil).observe(v, add)
 	}
+}
 
-	if !ok {
-		c = &value{}
-		c.data = v
-
-		theCtx.ctxLock.Lock()
-		if nameOnly {
-			theCtx.valuesByName[key] = c
-		} else {
-			theCtx.values[key] = c
-		}
-
-		theCtx.ctxLock.Unlock()
-	} else {
-		theCtx.ctxLock.Lock()
+// applyCounter does the increment for a counterMsg off the channel.
+func (r *Registry) applyCounter(cm counterMsg) {
+	switch {
+	case cm.labels != nil && cm.dist != "":
+		r.incrLabeledBucket(cm.dist, cm.name, cm.labels, cm.i)
+	case cm.labels != nil:
+		r.getOrMakeAndIncrLabeled(cm.name, cm.labels, cm.i)
+	case cm.dist != "":
+		r.incrBucket(cm.dist, cm.name, cm.suffix, cm.i)
+	default:
+		r.getOrMakeAndIncrCounter(cm.name, cm.suffix, cm.i)
+	}
+}
 
-		c.data = v // bad but no atomics and just 1/minute (from go stats)
+// applyValue does the set for a valueMsg off the channel.
+func (r *Registry) applyValue(vm valueMsg) {
+	if vm.labels != nil {
+		r.getOrMakeAndSetLabeled(vm.name, vm.labels, vm.v)
 
-		theCtx.ctxLock.Unlock()
+		return
 	}
+
+	r.getOrMakeAndObserveValue(vm.name, vm.suffix, vm.v, vm.add)
 }
 
-func readingCountsGoRoutine(index int) {
+func (r *Registry) readingCountsGoRoutine(c chan counterMsg, finished chan struct{}) {
+	defer r.wg.Done()
+
 	for {
 		select {
 		// no default because this should block
-		case <-theCtx.finished:
-			return
-		case cm := <-theCtx.c[index]:
-			getOrMakeAndIncrCounter(cm.name, cm.suffix, cm.i)
+		case <-finished:
+			// drain whatever was queued before Shutdown
+			for {
+				select {
+				case cm := <-c:
+					r.applyCounter(cm)
+				default:
+					return
+				}
+			}
+		case cm := <-c:
+			r.applyCounter(cm)
 		}
 	}
 }
 
 // InitCounters should be called at least once to start the go routines etc.
-func InitCounters() {
-	theCtx.ctxLock.Lock()
-	defer theCtx.ctxLock.Unlock()
+// It can be called again after Shutdown to start over with no counters,
+// waiting for a Shutdown that is still finishing.
+func (r *Registry) InitCounters() {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	for r.shutdownDone != nil { // a Shutdown is still finishing
+		done := r.shutdownDone
+
+		r.ctxLock.Unlock()
+		<-done
+		r.ctxLock.Lock()
+	}
 
-	if theCtx.started {
+	if r.started {
 		return
 	}
 
-	initCtx()
+	r.initCtx()
+
+	r.wg.Add(2*numChannels + 1)
 
 	// counters go routines
 	for i := range numChannels {
-		go readingCountsGoRoutine(i)
+		go r.readingCountsGoRoutine(r.c[i], r.finished)
 	}
 
 	// values go routines
 	for i := range numChannels {
-		go readingValGoRoutine(i)
+		go r.readingValGoRoutine(r.v[i], r.finished)
 	}
 
-	go minuteGoRoutine()
+	go r.minuteGoRoutine(r.finished, r.intervalChanged)
+
+	r.accepting.Store(true)
 }
 
-// SetMetricReporter specifies a function to be called once per
-// LogInterval with the names of the current metrics and the last
-// minute delta.
-func SetMetricReporter(fn MetricReporter) {
-	theCtx.ctxLock.Lock()
-	theCtx.logCb = fn
-	theCtx.ctxLock.Unlock()
+// Shutdown stops the async API accepting increments, drains the
+// counter and value channels, stops the go routines and does a final
+// LogCounters whose report is delivered to the reporters.  It
+// returns nil once all that is done or the context's error if it
+// expires first; in that case the shutdown carries on in the
+// background, without waiting on the reporters, and Shutdown or
+// InitCounters can be called again to wait for it.
+//
+// Increments made by the async API while Shutdown is starting may
+// be lost.
+func (r *Registry) Shutdown(ctx context.Context) error {
+	r.ctxLock.Lock()
+
+	if !r.started {
+		r.ctxLock.Unlock()
+
+		return nil
+	}
+
+	if r.shutdownDone == nil {
+		r.accepting.Store(false)
+		close(r.finished)
+
+		r.shutdownDone = make(chan struct{})
+
+		go r.finishShutdown(ctx, r.shutdownDone)
+	}
+
+	done := r.shutdownDone
+
+	r.ctxLock.Unlock()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
 }
 
-// SetValReporter specifies a function to be called once per
-// LogInterval with the names of the current metrics which are
-// float64s and the last minute delta.
-func SetValReporter(fn ValReporter) {
-	theCtx.ctxLock.Lock()
-	theCtx.valCb = fn
-	theCtx.ctxLock.Unlock()
+// finishShutdown waits for the go routines, emits the last report
+// and marks the Registry as stopped so InitCounters can run again.
+// Reporters are waited on no longer than ctx.
+func (r *Registry) finishShutdown(ctx context.Context, done chan struct{}) {
+	r.wg.Wait()
+	r.LogCounters()
+	r.stopReporters(ctx)
+
+	r.ctxLock.Lock()
+	r.started = false
+	r.shutdownDone = nil
+	r.ctxLock.Unlock()
+
+	close(done)
 }
 
-// SetLogInterval sets the number of seconds to sleep between logs of the counters.
-func SetLogInterval(i float64) {
-	theCtx.ctxLock.Lock()
-	theCtx.timeSleep = i
-	theCtx.ctxLock.Unlock()
+// SetLogInterval sets the number of seconds (fractions are fine)
+// between logs of the counters.  It takes effect straight away even
+// after InitCounters.
+func (r *Registry) SetLogInterval(i float64) {
+	r.interval.Store(int64(i * float64(time.Second)))
+
+	r.ctxLock.RLock()
+	changed := r.intervalChanged
+	r.ctxLock.RUnlock()
+
+	select {
+	case changed <- struct{}{}:
+	default: // already pending or not started
+	}
 }
 
 // SetFmtString sets the format string to log the counters with.  It must have a %s and two %d.
-func SetFmtString(fs string) {
-	theCtx.ctxLock.Lock()
-	theCtx.fmtString = fs // should validate
-	theCtx.ctxLock.Unlock()
+func (r *Registry) SetFmtString(fs string) {
+	r.ctxLock.Lock()
+	r.fmtString = fs // should validate
+	r.ctxLock.Unlock()
 }
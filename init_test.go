@@ -1,18 +1,18 @@
 package counters
 
 import (
+	"context"
 	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestGetOrMakeAndIncrCounter_RepeatedConcurrent(t *testing.T) {
 	for range 1000 {
 		TestGetOrMakeAndIncrCounter_Concurrent(t)
 		// Reset the counters after each run to ensure a clean state for the next iteration.
-		theCtx.ctxLock.Lock()
-		// Resetting countersByName, where the counter from the concurrent test should reside.
-		theCtx.countersByName = make(map[string]*counter)
-		theCtx.ctxLock.Unlock()
+		theCtx.ResetAll()
 	}
 }
 
@@ -39,7 +39,7 @@ func TestGetOrMakeAndIncrCounter_Concurrent(t *testing.T) {
 			start.Wait() // Wait for all goroutines to start
 
 			for range incrementsPerRoutine {
-				getOrMakeAndIncrCounter(metricName, "", 1)
+				theCtx.getOrMakeAndIncrCounter(metricName, "", 1)
 			}
 		}()
 	}
@@ -47,17 +47,91 @@ func TestGetOrMakeAndIncrCounter_Concurrent(t *testing.T) {
 	start.Done() // Start all goroutines
 	wg.Wait()    // Wait for all goroutines to finish
 
-	theCtx.ctxLock.RLock()
-	defer theCtx.ctxLock.RUnlock()
-
-	c, ok := theCtx.countersByName[metricName]
+	c, ok := theCtx.countersByName.get(metricName)
 	if !ok {
 		t.Fatalf("Metric %s not found", metricName)
 	}
 
 	expectedCount := int64(numRoutines * incrementsPerRoutine)
 
-	if c.data != expectedCount {
-		t.Errorf("Expected count %d, got %d", expectedCount, c.data)
+	if c.load() != expectedCount {
+		t.Errorf("Expected count %d, got %d", expectedCount, c.load())
+	}
+}
+
+func TestRegistryIndependent(t *testing.T) {
+	InitCounters()
+
+	r1 := NewRegistry()
+	r2 := NewRegistry()
+
+	r1.IncrSyncSuffix("tenant_metric", "a")
+	r1.IncrDeltaSyncSuffix("tenant_metric", 4, "a")
+	r2.IncrSyncSuffix("tenant_metric", "b")
+
+	if got := r1.ReadSync("tenant_metric"); got != 5 {
+		t.Errorf("Expected 5 in r1, got %d", got)
+	}
+
+	if got := r2.ReadSync("tenant_metric"); got != 1 {
+		t.Errorf("Expected 1 in r2, got %d", got)
+	}
+
+	if got := ReadSync("tenant_metric"); got != 0 {
+		t.Errorf("Expected default registry to be untouched, got %d", got)
+	}
+
+	r1.LogCounters()
+}
+
+func TestShutdownDrainsAndReports(t *testing.T) {
+	r := NewRegistry()
+
+	var reported int64
+
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		for _, m := range metrics {
+			if m.Name == "queued" {
+				atomic.StoreInt64(&reported, m.Delta)
+			}
+		}
+	})
+
+	for range 5000 {
+		r.IncrSuffix("queued", "test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+
+	if got := r.ReadSync("queued"); got != 5000 {
+		t.Errorf("Expected 5000 drained, got %d", got)
+	}
+
+	if got := atomic.LoadInt64(&reported); got != 5000 {
+		t.Errorf("Expected final report of 5000, got %d", got)
+	}
+
+	// after Shutdown the async API is a no-op
+	r.IncrSuffix("queued", "test")
+
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Second Shutdown failed %v", err)
+	}
+
+	// and it can be started again from scratch
+	r.InitCounters()
+	r.IncrSyncSuffix("restarted", "test")
+
+	if got := r.ReadSync("restarted"); got != 1 {
+		t.Errorf("Expected 1 after restart, got %d", got)
+	}
+
+	if err := r.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown after restart failed %v", err)
 	}
 }
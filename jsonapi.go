@@ -0,0 +1,338 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// this jsonapi.go file serves the registry as JSON for dashboards
+// polling a running process, with filtering, sorting and the recent
+// interval deltas.
+
+// CountersHandler returns an http.Handler serving the registry as
+// JSON, e.g. http.Handle("/counters", r.CountersHandler()).  Query
+// parameters, all optional:
+//
+//	name=glob     only names matching the glob (* and ?)
+//	regex=re      only names matching the regular expression
+//	type=t,...    only counter, value, meta and/or distribution
+//	sort=s        name (the default), total or delta, largest first
+//	top=N         only the first N of each type after sorting
+//	history=N     the last N (at most 120) interval deltas
+//
+// Distributions' totals and deltas are their buckets' summed.
+func (r *Registry) CountersHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		q, err := parseJSONQuery(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+
+			return
+		}
+
+		res := jsonResult(r.SnapshotHistory(q.history), q)
+		res.Interval = r.logInterval().Seconds()
+
+		w.Header().Set("Content-Type", "application/json")
+
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", " ")
+		_ = enc.Encode(res)
+	})
+}
+
+// jsonQuery is CountersHandler's parsed query parameters.
+type jsonQuery struct {
+	match   *regexp.Regexp // nil for everything
+	types   map[string]bool
+	sort    string
+	top     int
+	history int
+}
+
+var jsonTypes = []string{"counter", "value", "meta", "distribution"}
+
+func parseJSONQuery(req *http.Request) (jsonQuery, error) {
+	v := req.URL.Query()
+	q := jsonQuery{types: map[string]bool{}, sort: "name"}
+
+	switch {
+	case v.Get("regex") != "":
+		re, err := regexp.Compile(v.Get("regex"))
+		if err != nil {
+			return q, err
+		}
+
+		q.match = re
+	case v.Get("name") != "":
+		q.match = globRegexp(v.Get("name"))
+	}
+
+	if t := v.Get("type"); t != "" {
+		for _, t := range strings.Split(t, ",") {
+			t = strings.TrimSuffix(strings.TrimSpace(t), "s")
+			if !slices.Contains(jsonTypes, t) {
+				return q, badParam("type", t)
+			}
+
+			q.types[t] = true
+		}
+	} else {
+		for _, t := range jsonTypes {
+			q.types[t] = true
+		}
+	}
+
+	if s := v.Get("sort"); s != "" {
+		if s != "name" && s != "total" && s != "delta" {
+			return q, badParam("sort", s)
+		}
+
+		q.sort = s
+	}
+
+	for _, p := range []struct {
+		name string
+		to   *int
+	}{{"top", &q.top}, {"history", &q.history}} {
+		s := v.Get(p.name)
+		if s == "" {
+			continue
+		}
+
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return q, badParam(p.name, s)
+		}
+
+		*p.to = n
+	}
+
+	return q, nil
+}
+
+var errBadParam = errors.New("counters: bad query parameter")
+
+func badParam(name string, value string) error {
+	return fmt.Errorf("%w: %s=%q", errBadParam, name, value)
+}
+
+// globRegexp is the anchored regexp for a glob where * matches
+// anything (slashes too) and ? one character.
+func globRegexp(glob string) *regexp.Regexp {
+	re := regexp.QuoteMeta(glob)
+	re = strings.ReplaceAll(re, `\*`, ".*")
+	re = strings.ReplaceAll(re, `\?`, ".")
+
+	return regexp.MustCompile("^" + re + "$")
+}
+
+// jsonFloat is a float64 which is null in JSON if NaN or Inf.
+type jsonFloat float64
+
+func (f jsonFloat) MarshalJSON() ([]byte, error) {
+	if math.IsNaN(float64(f)) || math.IsInf(float64(f), 0) {
+		return []byte("null"), nil
+	}
+
+	return json.Marshal(float64(f))
+}
+
+func jsonFloats(fs []float64) []jsonFloat {
+	if fs == nil {
+		return nil
+	}
+
+	res := make([]jsonFloat, len(fs))
+	for i, f := range fs {
+		res[i] = jsonFloat(f)
+	}
+
+	return res
+}
+
+func jsonLabels(labels []Label) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+
+	res := make(map[string]string, len(labels))
+	for _, l := range labels {
+		res[l.Key] = l.Value
+	}
+
+	return res
+}
+
+type jsonCounter struct {
+	Name        string            `json:"name"`
+	Base        string            `json:"base"`
+	Suffix      string            `json:"suffix,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Total       int64             `json:"total"`
+	Delta       int64             `json:"delta"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastUpdated time.Time         `json:"last_updated"`
+	History     []jsonFloat       `json:"history,omitempty"`
+}
+
+type jsonValue struct {
+	Name        string            `json:"name"`
+	Base        string            `json:"base"`
+	Suffix      string            `json:"suffix,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Value       jsonFloat         `json:"value"`
+	Delta       jsonFloat         `json:"delta"`
+	Mode        string            `json:"mode"`
+	FirstSeen   time.Time         `json:"first_seen"`
+	LastUpdated time.Time         `json:"last_updated"`
+	History     []jsonFloat       `json:"history,omitempty"`
+}
+
+type jsonMeta struct {
+	Name  string    `json:"name"`
+	Total jsonFloat `json:"total"`
+	Delta jsonFloat `json:"delta"`
+}
+
+type jsonBucket struct {
+	Bucket  string      `json:"bucket"`
+	Lower   float64     `json:"lower"`
+	Upper   float64     `json:"upper"`
+	Total   int64       `json:"total"`
+	Delta   int64       `json:"delta"`
+	History []jsonFloat `json:"history,omitempty"`
+}
+
+type jsonDistribution struct {
+	Name    string            `json:"name"`
+	Suffix  string            `json:"suffix,omitempty"`
+	Labels  map[string]string `json:"labels,omitempty"`
+	Total   int64             `json:"total"`
+	Delta   int64             `json:"delta"`
+	Buckets []jsonBucket      `json:"buckets"`
+}
+
+// jsonResponse is what CountersHandler serves.  Types filtered out,
+// or with nothing matching, are left out.
+type jsonResponse struct {
+	Time          time.Time          `json:"time"`
+	Start         time.Time          `json:"start"`
+	Interval      float64            `json:"interval"` // seconds
+	Counters      []jsonCounter      `json:"counters,omitempty"`
+	Values        []jsonValue        `json:"values,omitempty"`
+	Meta          []jsonMeta         `json:"meta,omitempty"`
+	Distributions []jsonDistribution `json:"distributions,omitempty"`
+}
+
+// jsonResult filters, sorts and trims the snapshot per q.
+func jsonResult(s RegistrySnapshot, q jsonQuery) jsonResponse {
+	res := jsonResponse{Time: s.Time, Start: s.Start}
+	match := func(name string) bool { return q.match == nil || q.match.MatchString(name) }
+
+	if q.types["counter"] {
+		for _, c := range s.Counters {
+			if match(c.Name) {
+				res.Counters = append(res.Counters, jsonCounter{
+					c.Name, c.Base, c.Suffix, jsonLabels(c.Labels), c.Total, c.Delta,
+					c.FirstSeen, c.LastUpdated, jsonFloats(c.History),
+				})
+			}
+		}
+
+		res.Counters = sortTop(res.Counters, q, func(c jsonCounter) (float64, float64) {
+			return float64(c.Total), float64(c.Delta)
+		})
+	}
+
+	if q.types["value"] {
+		for _, v := range s.Values {
+			if match(v.Name) {
+				res.Values = append(res.Values, jsonValue{
+					v.Name, v.Base, v.Suffix, jsonLabels(v.Labels), jsonFloat(v.Value), jsonFloat(v.Delta),
+					v.Mode.String(), v.FirstSeen, v.LastUpdated, jsonFloats(v.History),
+				})
+			}
+		}
+
+		res.Values = sortTop(res.Values, q, func(v jsonValue) (float64, float64) {
+			return float64(v.Value), float64(v.Delta)
+		})
+	}
+
+	if q.types["meta"] {
+		for _, m := range s.Meta {
+			if match(m.Name) {
+				res.Meta = append(res.Meta, jsonMeta{m.Name, jsonFloat(m.Total), jsonFloat(m.Delta)})
+			}
+		}
+
+		res.Meta = sortTop(res.Meta, q, func(m jsonMeta) (float64, float64) {
+			return float64(m.Total), float64(m.Delta)
+		})
+	}
+
+	if q.types["distribution"] {
+		for _, d := range s.Distributions {
+			if match(d.Name) {
+				res.Distributions = append(res.Distributions, jsonDistributionFor(d))
+			}
+		}
+
+		res.Distributions = sortTop(res.Distributions, q, func(d jsonDistribution) (float64, float64) {
+			return float64(d.Total), float64(d.Delta)
+		})
+	}
+
+	return res
+}
+
+func jsonDistributionFor(d DistributionSnapshot) jsonDistribution {
+	jd := jsonDistribution{Name: d.Name, Suffix: d.Suffix, Labels: jsonLabels(d.Labels)}
+
+	for _, b := range d.Buckets {
+		jd.Total += b.Total
+		jd.Delta += b.Delta
+		jd.Buckets = append(jd.Buckets, jsonBucket{b.Bucket, b.Lower, b.Upper, b.Total, b.Delta, jsonFloats(b.History)})
+	}
+
+	return jd
+}
+
+// sortTop sorts xs, already in name order, by total or delta
+// (largest first, NaNs last) per q and keeps the top q.top.
+func sortTop[T any](xs []T, q jsonQuery, key func(T) (float64, float64)) []T {
+	if q.sort != "name" {
+		by := func(x T) float64 {
+			total, delta := key(x)
+			if q.sort == "delta" {
+				return delta
+			}
+
+			return total
+		}
+
+		sort.SliceStable(xs, func(i, j int) bool {
+			a, b := by(xs[i]), by(xs[j])
+
+			return a > b || (!math.IsNaN(a) && math.IsNaN(b))
+		})
+	}
+
+	if q.top > 0 && len(xs) > q.top {
+		xs = xs[:q.top]
+	}
+
+	return xs
+}
@@ -0,0 +1,117 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func getCounters(t *testing.T, r *Registry, query string) jsonResponse {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	r.CountersHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/counters"+query, nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200 for %s got %d %s", query, rec.Code, rec.Body)
+	}
+
+	var res jsonResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &res); err != nil {
+		t.Fatalf("Bad JSON for %s: %v", query, err)
+	}
+
+	return res
+}
+
+func TestCountersHandler(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	r.IncrDeltaSyncSuffix("api_small", 1, "a")
+	r.IncrDeltaSyncSuffix("api_big", 10, "a")
+	r.IncrDeltaSyncSuffix("other", 5, "a")
+	r.NewValue("api_temp").Set(21.5)
+	r.NewValue("api_nan").Set(math.NaN())
+	r.IncrDeltaSyncSuffix("api_good", 9, "a")
+	r.IncrDeltaSyncSuffix("api_bad", 1, "a")
+	r.AddMetaCounter("api_avail", "api_good", "api_bad", RatioTotal)
+	r.MarkDistributionSyncSuffix("api_dist", 1.0, "a")
+	r.MarkDistributionSyncSuffix("api_dist", 1113.0, "a")
+
+	r.LogCounters()
+	r.IncrDeltaSyncSuffix("api_small", 20, "a")
+	r.LogCounters()
+	r.IncrDeltaSyncSuffix("api_small", 3, "a")
+
+	res := getCounters(t, r, "")
+	if len(res.Counters) == 0 || len(res.Values) != 2 || len(res.Meta) != 1 || len(res.Distributions) != 1 {
+		t.Errorf("Expected every type got %+v", res)
+	}
+
+	if res.Interval != 60 {
+		t.Errorf("Expected a 60s interval got %v", res.Interval)
+	}
+
+	if d := res.Distributions[0]; d.Total != 2 || len(d.Buckets) != 2 {
+		t.Errorf("Expected 2 api_dist buckets got %+v", d)
+	}
+
+	res = getCounters(t, r, "?name=api_*&type=counter&sort=total&top=2")
+	if len(res.Values) != 0 || len(res.Meta) != 0 || len(res.Distributions) != 0 {
+		t.Errorf("Expected only counters got %+v", res)
+	}
+
+	if len(res.Counters) != 2 || res.Counters[0].Name != "api_small" || res.Counters[1].Name != "api_big" {
+		t.Errorf("Expected api_small then api_big got %+v", res.Counters)
+	}
+
+	res = getCounters(t, r, "?regex=^api_(big|small)$&type=counters&sort=delta")
+	if len(res.Counters) != 2 || res.Counters[0].Name != "api_small" || res.Counters[0].Delta != 3 {
+		t.Errorf("Expected api_small first by delta got %+v", res.Counters)
+	}
+
+	res = getCounters(t, r, "?name=api_small&history=5")
+	if len(res.Counters) != 1 {
+		t.Fatalf("Expected api_small got %+v", res.Counters)
+	}
+
+	if h := res.Counters[0].History; len(h) != 2 || h[0] != 1 || h[1] != 20 {
+		t.Errorf("Expected history [1 20] got %v", h)
+	}
+
+	res = getCounters(t, r, "?type=value&sort=total")
+	if len(res.Values) != 2 || res.Values[0].Name != "api_temp" {
+		t.Errorf("Expected api_temp before the NaN got %+v", res.Values)
+	}
+
+	for _, q := range []string{"?type=gauge", "?sort=size", "?top=-1", "?history=x", "?regex=("} {
+		rec := httptest.NewRecorder()
+		r.CountersHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/counters"+q, nil))
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for %s got %d", q, rec.Code)
+		}
+	}
+}
+
+func TestDeltasRing(t *testing.T) {
+	d := deltas{}
+
+	for i := range maxHistory + 5 {
+		d.add(float64(i))
+	}
+
+	h := d.lastN(3)
+	if len(h) != 3 || h[0] != maxHistory+2 || h[2] != maxHistory+4 {
+		t.Errorf("Expected the last 3 oldest first got %v", h)
+	}
+
+	if len(d.lastN(1000)) != maxHistory || d.lastN(0) != nil {
+		t.Errorf("Expected lastN capped at %d", maxHistory)
+	}
+}
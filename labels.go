@@ -0,0 +1,131 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this labels.go file adds key/value labels as structured dimensions
+// to counters, values and distributions, next to the older name/suffix
+// strings.
+
+import (
+	"sort"
+	"strings"
+)
+
+// Label is one key/value dimension of a counter, value or
+// distribution.
+type Label struct {
+	Key   string
+	Value string
+}
+
+// L makes a Label, e.g. IncrLabels("http_requests", L("code", "500")).
+func L(key string, value string) Label {
+	return Label{key, value}
+}
+
+// sortedLabels returns a copy of labels sorted by key with only the
+// last of any repeated key (never nil so the channel readers know it
+// is a labeled message).
+func sortedLabels(labels []Label) []Label {
+	ls := make([]Label, len(labels))
+	copy(ls, labels)
+
+	sort.SliceStable(ls, func(i, j int) bool { return ls[i].Key < ls[j].Key })
+
+	res := ls[:0]
+
+	for i, l := range ls {
+		if i+1 < len(ls) && ls[i+1].Key == l.Key {
+			continue // a later one wins
+		}
+
+		res = append(res, l)
+	}
+
+	return res
+}
+
+// labelEscaper backslash escapes what labelKey uses as separators.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `,`, `\,`, `=`, `\=`, `}`, `\}`)
+
+// labelKey returns the map key and log name for name with the sorted
+// labels, e.g. http_requests{code=500,route=/x}; \ , = and } in keys
+// and values are backslash escaped so different labels never make the
+// same key.
+func labelKey(name string, labels []Label) string {
+	var b strings.Builder
+
+	b.WriteString(name)
+	b.WriteByte('{')
+
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+
+		_, _ = labelEscaper.WriteString(&b, l.Key)
+		b.WriteByte('=')
+		_, _ = labelEscaper.WriteString(&b, l.Value)
+	}
+
+	b.WriteByte('}')
+
+	return b.String()
+}
+
+// IncrLabels adds one to the counter name with the given labels.  The
+// merged total for name is incremented too.
+func (r *Registry) IncrLabels(name string, labels ...Label) {
+	r.IncrDeltaLabels(name, 1, labels...)
+}
+
+// IncrDeltaLabels adds i to the counter name with the given labels.
+func (r *Registry) IncrDeltaLabels(name string, i int64, labels ...Label) {
+	r.sendCounter(counterMsg{name: name, i: i, labels: sortedLabels(labels)})
+}
+
+// IncrDeltaSyncLabels is the sync version of IncrDeltaLabels.
+func (r *Registry) IncrDeltaSyncLabels(name string, i int64, labels ...Label) {
+	r.getOrMakeAndIncrLabeled(name, sortedLabels(labels), i)
+}
+
+// SetLabels sets the value name with the given labels.  The merged
+// value for name is set too.
+func (r *Registry) SetLabels(name string, val float64, labels ...Label) {
+	r.sendValue(valueMsg{name: name, v: val, labels: sortedLabels(labels)})
+}
+
+// MarkDistributionLabels marks the histogram bucket for value in the
+// distribution name with the given labels.
+func (r *Registry) MarkDistributionLabels(name string, value float64, labels ...Label) {
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, "", labels)
+	r.sendCounter(counterMsg{name: derived, i: 1, labels: sortedLabels(labels), dist: name})
+}
+
+// getOrMakeAndIncrLabeled increments the merged counter for name and
+// the one for name with the (sorted) labels, making them as needed.
+func (r *Registry) getOrMakeAndIncrLabeled(name string, labels []Label, i int64) {
+	key := labelKey(name, labels)
+
+	r.getOrMakeCounter(r.countersByName, name, name, "", nil).add(i)
+	r.getOrMakeCounter(r.counters, key, name, "", labels).add(i)
+}
+
+// incrLabeledBucket is getOrMakeAndIncrLabeled for the bucket derived
+// of the distribution name.
+func (r *Registry) incrLabeledBucket(name string, derived string, labels []Label, i int64) {
+	key := labelKey(derived, labels)
+
+	r.bucketCounter(name, derived).add(i)
+	r.getOrMakeCounter(r.counters, key, derived, "", labels).add(i)
+}
+
+// getOrMakeAndSetLabeled sets the merged value for name and the one
+// for name with the (sorted) labels, making them as needed.
+func (r *Registry) getOrMakeAndSetLabeled(name string, labels []Label, v float64) {
+	key := labelKey(name, labels)
+
+	r.getOrMakeValue(r.valuesByName, name, name, "", nil).set(v)
+	r.getOrMakeValue(r.values, key, name, "", labels).set(v)
+}
@@ -0,0 +1,92 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLabels(t *testing.T) {
+	r := NewRegistry()
+
+	var reports []MetricReport
+
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		reports = metrics
+	})
+
+	r.IncrLabels("http_requests", L("route", "/x"), L("code", "500"))
+	r.IncrLabels("http_requests", L("code", "500"), L("route", "/x"))
+	r.IncrDeltaSyncLabels("http_requests", 5, L("route", "/y"), L("code", "200"))
+	r.SetLabels("queue_depth", 12, L("queue", "a"))
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+
+	if got := r.ReadSync("http_requests{code=500,route=/x}"); got != 2 {
+		t.Errorf("Expected label order not to matter and 2, got %d", got)
+	}
+
+	if got := r.ReadSync("http_requests"); got != 7 {
+		t.Errorf("Expected merged total 7, got %d", got)
+	}
+
+	found := false
+
+	for _, m := range reports {
+		if m.Name == "http_requests{code=200,route=/y}" {
+			found = true
+
+			if len(m.Labels) != 2 || m.Labels[0] != L("code", "200") || m.Labels[1] != L("route", "/y") {
+				t.Errorf("Expected sorted labels in the report, got %v", m.Labels)
+			}
+		}
+	}
+
+	if !found {
+		t.Errorf("Labeled counter not reported %v", reports)
+	}
+
+	v, ok := r.values.get("queue_depth{queue=a}")
+
+	if !ok || v.data != 12 || v.labels[0].Value != "a" {
+		t.Errorf("Expected labeled value of 12, got %v", v)
+	}
+}
+
+// TestLabelKeyEscaping checks labels with separators in them don't
+// collide with other label sets, and repeated keys keep the last.
+func TestLabelKeyEscaping(t *testing.T) {
+	r := NewRegistry()
+
+	r.IncrDeltaSyncLabels("esc", 1, L("a", "1,b=2"))
+	r.IncrDeltaSyncLabels("esc", 1, L("a", "1"), L("b", "2"))
+	r.IncrDeltaSyncLabels("esc", 1, L("a", "x"), L("a", "y"))
+
+	s := r.Snapshot()
+
+	got := map[string]int64{}
+
+	for _, c := range s.Counters {
+		if c.Base == "esc" && len(c.Labels) > 0 {
+			got[c.Name] = c.Total
+		}
+	}
+
+	want := map[string]int64{`esc{a=1\,b\=2}`: 1, "esc{a=1,b=2}": 1, "esc{a=y}": 1}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v got %v", want, got)
+	}
+
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("Expected %d in %s got %v", n, k, got)
+		}
+	}
+
+	if k := labelKey("n", []Label{L(`k}`, `v\`)}); k != `n{k\}=v\\}` {
+		t.Errorf("Bad escaping %s", k)
+	}
+}
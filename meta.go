@@ -5,22 +5,21 @@ package counters
 
 import (
 	"log"
+	"sort"
+	"strings"
 )
 
-// AddMetaCounter adds in a CB to calculate a new number based on other counters.
-func AddMetaCounter(name string,
+// AddMetaCounter adds in a CB to calculate a new number based on
+// other counters.  If name is PerCaller (see SetSuffixMode) the
+// meta counter is also calculated per suffix of c1 and c2.
+func (r *Registry) AddMetaCounter(name string,
 	c1 string,
 	c2 string,
 	f MetaCounterF,
 ) {
-	suffix := getCallerFunctionName()
-
-	theCtx.ctxLock.Lock()
-
-	// adding the suffix to counter names keeps APi compatibility but is less useful
-	theCtx.metaCtrs[name+"/"+suffix] = &metaCounter{name + "/" + suffix, c1 + "/" + suffix, c2 + "/" + suffix, f}
-
-	theCtx.ctxLock.Unlock()
+	r.ctxLock.Lock()
+	r.metaCtrs[name] = &metaCounter{name, c1, c2, f}
+	r.ctxLock.Unlock()
 }
 
 // MetaCounterF is a function taking two ints and returning a calculated float64 for a new counter-type thing which is derived from 2 other ones.
@@ -31,24 +30,100 @@ func RatioTotal(a int64, b int64) float64 {
 	return float64(a) / (float64(a) + float64(b))
 }
 
-func logMetaCounter(mc *metaCounter, cs map[string]*counter) {
-	c1, ok := cs[mc.c1]
+// metaNames returns the sorted meta counter names.  Must hold ctxLock.
+func (r *Registry) metaNames() []string {
+	names := make([]string, 0, len(r.metaCtrs))
+
+	for k := range r.metaCtrs {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// logMetaLines logs a meta counter's lines from metaLines.
+func (r *Registry) logMetaLines(lines []MetaSnapshot) {
+	for _, m := range lines {
+		log.Printf(
+			r.fmtStringF64,
+			m.Name,
+			m.Total,
+			m.Delta,
+		)
+	}
+}
+
+// metaLines calculates the merged meta counter and, if it is
+// PerCaller, one per suffix.  Must hold ctxLock.
+func (r *Registry) metaLines(mc *metaCounter) []MetaSnapshot {
+	c1, ok := r.countersByName.get(mc.c1)
 	if !ok {
-		return
+		return nil
 	}
 
-	c2, ok := cs[mc.c2]
+	c2, ok := r.countersByName.get(mc.c2)
 	if !ok {
-		return
+		return nil
+	}
+
+	lines := []MetaSnapshot{metaLine(mc.name, mc.f, c1, c2)}
+
+	if r.suffixMode(mc.name) != PerCaller {
+		return lines
+	}
+
+	for _, suffix := range r.metaSuffixes(mc) {
+		c1, ok := r.counters.get(mc.c1 + "/" + suffix)
+		if !ok {
+			c1 = newCounter()
+		}
+
+		c2, ok := r.counters.get(mc.c2 + "/" + suffix)
+		if !ok {
+			c2 = newCounter()
+		}
+
+		lines = append(lines, metaLine(mc.name+"/"+suffix, mc.f, c1, c2))
+	}
+
+	return lines
+}
+
+// metaSuffixes returns the sorted suffixes seen for either of the
+// meta counter's counters.  Must hold ctxLock.
+func (r *Registry) metaSuffixes(mc *metaCounter) []string {
+	seen := make(map[string]bool)
+
+	for _, e := range r.counters.entries() {
+		k := e.key
+
+		for _, prefix := range []string{mc.c1 + "/", mc.c2 + "/"} {
+			if strings.HasPrefix(k, prefix) {
+				seen[k[len(prefix):]] = true
+			}
+		}
+	}
+
+	suffixes := make([]string, 0, len(seen))
+
+	for s := range seen {
+		suffixes = append(suffixes, s)
 	}
 
-	vTotal := mc.f(c1.data, c2.data)
-	vDelta := mc.f(c1.data-c1.oldData, c2.data-c2.oldData)
+	sort.Strings(suffixes)
 
-	log.Printf(
-		theCtx.fmtStringF64,
-		mc.name,
-		vTotal,
-		vDelta,
-	)
+	return suffixes
+}
+
+func metaLine(name string, f MetaCounterF, c1 *counter, c2 *counter) MetaSnapshot {
+	d1 := c1.load()
+	d2 := c2.load()
+
+	return MetaSnapshot{
+		Name:  name,
+		Total: f(d1, d2),
+		Delta: f(d1-c1.oldData, d2-c2.oldData),
+	}
 }
@@ -0,0 +1,140 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bufio"
+	"maps"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// this openmetrics.go file has what OpenMetrics adds to the
+// Prometheus format (see prometheus.go): units, exemplars on the
+// distribution buckets and the Accept header negotiation.
+
+const omContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+
+// maxExemplarRunes is the OpenMetrics limit on an exemplar's labels.
+const maxExemplarRunes = 128
+
+// Exemplar is an example of a marked value, e.g. with the trace_id
+// of the request, shown on its bucket by OpenMetrics.
+type Exemplar struct {
+	Labels []Label
+	Value  float64
+	Time   time.Time
+}
+
+// MarkDistributionExemplar is MarkDistributionSync keeping labels
+// (e.g. L("trace_id", id)) as the bucket's latest exemplar.
+func (r *Registry) MarkDistributionExemplar(name string, value float64, labels ...Label) {
+	r.markExemplar(name, value, getCallerFunctionName(), labels)
+}
+
+// MarkExemplar marks the histogram bucket for value keeping labels as
+// its exemplar, see MarkDistributionExemplar.
+func (h *Distribution) MarkExemplar(value float64, labels ...Label) {
+	h.r.markExemplar(h.name, value, "", labels)
+}
+
+func (r *Registry) markExemplar(name string, value float64, suffix string, labels []Label) {
+	derived := r.deriveDistName(name, value)
+	r.observeSample(name, value, suffix, nil)
+	r.incrBucket(name, derived, suffix, 1)
+
+	ex := &Exemplar{Labels: append([]Label(nil), labels...), Value: value, Time: time.Now()}
+
+	if c, ok := r.countersByName.get(derived); ok {
+		c.exemplar.Store(ex)
+	}
+
+	if r.suffixKey(name, suffix) != "" {
+		if c, ok := r.counters.get(derived + "/" + suffix); ok {
+			c.exemplar.Store(ex)
+		}
+	}
+}
+
+// newerExemplar returns the later of a and b, either may be nil.
+func newerExemplar(a *Exemplar, b *Exemplar) *Exemplar {
+	if a == nil || (b != nil && b.Time.After(a.Time)) {
+		return b
+	}
+
+	return a
+}
+
+// writeExemplar writes " # {labels} value timestamp", leaving out
+// exemplars whose labels are over the OpenMetrics limit.
+func writeExemplar(w *bufio.Writer, ex *Exemplar) {
+	n := 0
+
+	for _, l := range ex.Labels {
+		n += len([]rune(l.Key)) + len([]rune(l.Value))
+	}
+
+	if n > maxExemplarRunes {
+		return
+	}
+
+	w.WriteString(" # ")
+
+	if len(ex.Labels) == 0 {
+		w.WriteString("{}")
+	}
+
+	writePromLabels(w, ex.Labels)
+	w.WriteString(" " + formatFloat(ex.Value))
+	w.WriteString(" " + strconv.FormatFloat(float64(ex.Time.UnixNano())/1e9, 'f', 3, 64)) //nolint:mnd
+}
+
+// SetUnit sets the unit, e.g. seconds or bytes, of the counter, value
+// or distribution name.  OpenMetrics shows it as # UNIT; in both
+// formats it is added to the end of the name if not already there.
+func (r *Registry) SetUnit(name string, unit string) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	if r.units == nil {
+		r.units = make(map[string]string)
+	}
+
+	r.units[name] = promLabelName(unit)
+}
+
+func (r *Registry) unitsCopy() map[string]string {
+	r.ctxLock.RLock()
+	defer r.ctxLock.RUnlock()
+
+	return maps.Clone(r.units)
+}
+
+// wantsOpenMetrics is true if the Accept header rates OpenMetrics at
+// least as highly as the plain text format.
+func wantsOpenMetrics(accept string) bool {
+	omQ, textQ := 0.0, 0.0
+
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		q := 1.0
+
+		for _, p := range params[1:] {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					q = f
+				}
+			}
+		}
+
+		switch strings.TrimSpace(params[0]) {
+		case "application/openmetrics-text":
+			omQ = max(omQ, q)
+		case "text/plain", "text/*", "*/*":
+			textQ = max(textQ, q)
+		}
+	}
+
+	return omQ > 0 && omQ >= textQ
+}
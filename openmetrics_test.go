@@ -0,0 +1,78 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestOpenMetrics(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+	r.SetUnit("latency", "seconds")
+
+	r.IncrDeltaSyncSuffix("requests_total", 3, "a")
+	r.NewValue("temp").Set(21.5)
+
+	d := r.NewDistribution("latency")
+	d.Mark(1.0)
+	d.MarkExemplar(1113.0, L("trace_id", "abc123"))
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0,text/plain;version=0.0.4;q=0.5")
+
+	rec := httptest.NewRecorder()
+	r.PrometheusHandler().ServeHTTP(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/openmetrics-text") {
+		t.Errorf("Bad content type %s", ct)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		"# TYPE requests counter\nrequests_total 3\nrequests_created ",
+		"# TYPE temp gauge\ntemp 21.5\n",
+		"# TYPE latency_seconds histogram\n# UNIT latency_seconds seconds\n",
+		`latency_seconds_bucket{le="1.1"} 1` + "\n",
+		`latency_seconds_bucket{le="1200"} 2 # {trace_id="abc123"} 1113 `,
+		"latency_seconds_count 2\nlatency_seconds_created ",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+
+	if !strings.HasSuffix(out, "# EOF\n") {
+		t.Errorf("Expected # EOF at the end\n%s", out)
+	}
+
+	// and the classic format without the OpenMetrics bits
+	rec = httptest.NewRecorder()
+	r.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ = io.ReadAll(rec.Body)
+
+	if out := string(body); strings.Contains(out, "# EOF") || strings.Contains(out, "trace_id") ||
+		!strings.Contains(out, "requests_total 3\n") {
+		t.Errorf("Expected the text format\n%s", out)
+	}
+}
+
+func TestWantsOpenMetrics(t *testing.T) {
+	for accept, want := range map[string]bool{
+		"":                             false,
+		"text/plain;version=0.0.4":     false,
+		"application/openmetrics-text": true,
+		"application/openmetrics-text;q=0.5,text/plain":     false,
+		"application/openmetrics-text,*/*;q=0.1":            true,
+		"application/openmetrics-text;q=0,text/plain;q=0.1": false,
+	} {
+		if got := wantsOpenMetrics(accept); got != want {
+			t.Errorf("Expected %v for %q", want, accept)
+		}
+	}
+}
@@ -0,0 +1,389 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// this otlp.go file exports each interval to an OpenTelemetry
+// collector as OTLP/HTTP JSON: counters as cumulative Sums, values
+// and meta counters as Gauges and distributions as explicit bucket
+// Histograms.
+
+const otlpScope = "github.com/jayalane/go-counter"
+
+// OTLP aggregation temporality, from the OTLP protobuf enum.
+const otlpCumulative = 2
+
+// OTLPOptions configure an OTLP exporter; zero fields get the
+// defaults.
+type OTLPOptions struct {
+	Endpoint    string            // default http://localhost:4318/v1/metrics
+	ServiceName string            // service.name, default the program name
+	Attributes  []Label           // more resource attributes
+	Headers     map[string]string // e.g. for authentication
+	Retries     int               // of retryable failures, default 3; -1 for none
+	Backoff     time.Duration     // before the first retry, doubling after, default 1s
+	Timeout     time.Duration     // for a report, retries and all, default 30s
+	Client      *http.Client      // default http.DefaultClient
+}
+
+// OTLP is a Reporter posting to an OTLP/HTTP endpoint.
+type OTLP struct {
+	r        *Registry
+	opts     OTLPOptions
+	resource otlpResource
+}
+
+// NewOTLP adds a Reporter exporting to an OpenTelemetry collector.
+// It retries itself, honouring Retry-After, on 429, 502, 503 and 504
+// and network errors but not on other failures.
+func (r *Registry) NewOTLP(opts OTLPOptions) *OTLP {
+	if opts.Endpoint == "" {
+		opts.Endpoint = "http://localhost:4318/v1/metrics"
+	}
+
+	if opts.ServiceName == "" {
+		opts.ServiceName = filepath.Base(os.Args[0])
+	}
+
+	if opts.Retries == 0 {
+		opts.Retries = 3
+	}
+
+	opts.Retries = max(opts.Retries, 0)
+
+	if opts.Backoff <= 0 {
+		opts.Backoff = time.Second
+	}
+
+	if opts.Timeout <= 0 {
+		opts.Timeout = 30 * time.Second //nolint:mnd
+	}
+
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+
+	host, _ := os.Hostname()
+
+	o := &OTLP{r: r, opts: opts}
+	o.resource.Attributes = []otlpKeyValue{
+		otlpString("service.name", opts.ServiceName),
+		otlpString("host.name", host),
+		{Key: "process.pid", Value: otlpAnyValue{IntValue: strconv.Itoa(os.Getpid())}},
+	}
+
+	for _, l := range opts.Attributes {
+		o.resource.Attributes = append(o.resource.Attributes, otlpString(l.Key, l.Value))
+	}
+
+	r.AddReporterOptions(o, ReporterOptions{Timeout: opts.Timeout, Retries: -1})
+
+	return o
+}
+
+// Close removes the OTLP exporter from the registry.
+func (o *OTLP) Close() error {
+	o.r.RemoveReporter(o)
+
+	return nil
+}
+
+// the OTLP JSON encoding; 64 bit integers are strings.
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScopeInfo `json:"scope"`
+	Metrics []otlpMetric  `json:"metrics"`
+}
+
+type otlpScopeInfo struct {
+	Name string `json:"name"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+type otlpMetric struct {
+	Name      string         `json:"name"`
+	Unit      string         `json:"unit,omitempty"`
+	Sum       *otlpSum       `json:"sum,omitempty"`
+	Gauge     *otlpGauge     `json:"gauge,omitempty"`
+	Histogram *otlpHistogram `json:"histogram,omitempty"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpNumberPoint `json:"dataPoints"`
+	AggregationTemporality int               `json:"aggregationTemporality"`
+	IsMonotonic            bool              `json:"isMonotonic"`
+}
+
+type otlpGauge struct {
+	DataPoints []otlpNumberPoint `json:"dataPoints"`
+}
+
+type otlpNumberPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano,omitempty"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsInt             string         `json:"asInt,omitempty"`
+	AsDouble          *float64       `json:"asDouble,omitempty"`
+}
+
+type otlpHistogram struct {
+	DataPoints             []otlpHistogramPoint `json:"dataPoints"`
+	AggregationTemporality int                  `json:"aggregationTemporality"`
+}
+
+type otlpHistogramPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	Count             string         `json:"count"`
+	BucketCounts      []string       `json:"bucketCounts"`
+	ExplicitBounds    []float64      `json:"explicitBounds"`
+}
+
+func otlpString(key string, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// otlpAttributes is the suffix, if any, and the labels.
+func otlpAttributes(suffix string, labels []Label) []otlpKeyValue {
+	res := []otlpKeyValue{}
+
+	for _, l := range promLabels(suffix, labels) {
+		res = append(res, otlpString(l.Key, l.Value))
+	}
+
+	return res
+}
+
+func otlpNanos(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// Report posts rep, retrying as set out in the OTLPOptions.
+func (o *OTLP) Report(ctx context.Context, rep *Report) error {
+	body, err := json.Marshal(o.request(rep))
+	if err != nil {
+		return err
+	}
+
+	backoff := o.opts.Backoff
+
+	for try := 0; ; try++ {
+		wait, err := o.post(ctx, body)
+		if err == nil || wait < 0 || try >= o.opts.Retries {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(max(wait, backoff)):
+		}
+
+		backoff *= 2
+	}
+}
+
+// errOTLPStatus is a failed response; post's wait says whether it is
+// worth retrying.
+var errOTLPStatus = errors.New("otlp: request failed")
+
+// post sends body once.  wait is how long the server asked to wait
+// before a retry, or -1 if the failure isn't worth retrying.
+func (o *OTLP) post(ctx context.Context, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, o.opts.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return -1, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	for k, v := range o.opts.Headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := o.opts.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512)) //nolint:mnd
+
+	switch {
+	case resp.StatusCode/100 == 2: //nolint:mnd
+		return 0, nil
+	case resp.StatusCode == http.StatusTooManyRequests, resp.StatusCode == http.StatusBadGateway,
+		resp.StatusCode == http.StatusServiceUnavailable, resp.StatusCode == http.StatusGatewayTimeout:
+		wait := time.Duration(0)
+		if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			wait = time.Duration(s) * time.Second
+		}
+
+		return wait, fmt.Errorf("%w: %s: %s", errOTLPStatus, resp.Status, bytes.TrimSpace(msg))
+	}
+
+	return -1, fmt.Errorf("%w: %s: %s", errOTLPStatus, resp.Status, bytes.TrimSpace(msg))
+}
+
+// request converts rep, grouping data points by metric name, with
+// the merged series as in PrometheusHandler and SetUnit's units.
+func (o *OTLP) request(rep *Report) otlpRequest {
+	start, now := otlpNanos(rep.Start), otlpNanos(rep.Time)
+	units := o.r.unitsCopy()
+	metrics := map[string]*otlpMetric{}
+	metric := func(name string) *otlpMetric {
+		m, ok := metrics[name]
+		if !ok {
+			m = &otlpMetric{Name: name, Unit: units[name]}
+			metrics[name] = m
+		}
+
+		return m
+	}
+
+	rep = rep.exported()
+
+	for _, c := range rep.Counters {
+		m := metric(c.Base)
+		if m.Sum == nil { // not monotonic, counters can go down and be reset
+			m.Sum = &otlpSum{AggregationTemporality: otlpCumulative}
+		}
+
+		m.Sum.DataPoints = append(m.Sum.DataPoints, otlpNumberPoint{
+			Attributes:        otlpAttributes(c.Suffix, c.Labels),
+			StartTimeUnixNano: start,
+			TimeUnixNano:      now,
+			AsInt:             strconv.FormatInt(c.Total, 10),
+		})
+	}
+
+	gauge := func(name string, suffix string, labels []Label, v float64) {
+		m := metric(name)
+		if m.Gauge == nil {
+			m.Gauge = &otlpGauge{}
+		}
+
+		m.Gauge.DataPoints = append(m.Gauge.DataPoints, otlpNumberPoint{
+			Attributes:   otlpAttributes(suffix, labels),
+			TimeUnixNano: now,
+			AsDouble:     &v,
+		})
+	}
+
+	for _, v := range rep.Values {
+		gauge(v.Base, v.Suffix, v.Labels, v.Value)
+	}
+
+	for _, mc := range rep.Meta {
+		name, suffix, _ := strings.Cut(mc.Name, "/")
+		gauge(name, suffix, nil, mc.Total)
+	}
+
+	for _, d := range rep.Distributions {
+		m := metric(d.Name)
+		if m.Histogram == nil {
+			m.Histogram = &otlpHistogram{AggregationTemporality: otlpCumulative}
+		}
+
+		m.Histogram.DataPoints = append(m.Histogram.DataPoints, otlpHistogramFor(d, start, now))
+	}
+
+	names := make([]string, 0, len(metrics))
+	for n := range metrics {
+		names = append(names, n)
+	}
+
+	sort.Strings(names)
+
+	sm := otlpScopeMetrics{Scope: otlpScopeInfo{Name: otlpScope}}
+	for _, n := range names {
+		sm.Metrics = append(sm.Metrics, *metrics[n])
+	}
+
+	return otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource:     o.resource,
+		ScopeMetrics: []otlpScopeMetrics{sm},
+	}}}
+}
+
+// otlpHistogramFor makes the bounds from the buckets' upper bounds;
+// buckets whose range isn't known go in the last (overflow) count.
+func otlpHistogramFor(d DistributionReport, start string, now string) otlpHistogramPoint {
+	buckets := append([]BucketReport(nil), d.Buckets...)
+	sort.SliceStable(buckets, func(i, j int) bool { return buckets[i].Upper < buckets[j].Upper })
+
+	p := otlpHistogramPoint{
+		Attributes:        otlpAttributes(d.Suffix, d.Labels),
+		StartTimeUnixNano: start,
+		TimeUnixNano:      now,
+		ExplicitBounds:    []float64{},
+	}
+
+	counts := []int64{}
+	overflow, total := int64(0), int64(0)
+
+	for _, b := range buckets {
+		total += b.Total
+
+		if !b.Known {
+			overflow += b.Total
+
+			continue
+		}
+
+		if n := len(p.ExplicitBounds); n > 0 && p.ExplicitBounds[n-1] == b.Upper {
+			counts[n-1] += b.Total
+
+			continue
+		}
+
+		p.ExplicitBounds = append(p.ExplicitBounds, b.Upper)
+		counts = append(counts, b.Total)
+	}
+
+	for _, c := range append(counts, overflow) {
+		p.BucketCounts = append(p.BucketCounts, strconv.FormatInt(c, 10))
+	}
+
+	p.Count = strconv.FormatInt(total, 10)
+
+	return p
+}
@@ -0,0 +1,177 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestOTLP(t *testing.T) {
+	var (
+		calls atomic.Int32
+		got   otlpRequest
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if calls.Add(1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+
+			return
+		}
+
+		body, _ := io.ReadAll(req.Body)
+		if req.URL.Path != "/v1/metrics" || req.Header.Get("Content-Type") != "application/json" ||
+			json.Unmarshal(body, &got) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+
+			return
+		}
+	}))
+	defer srv.Close()
+
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+	r.SetUnit("lat", "seconds")
+
+	o := r.NewOTLP(OTLPOptions{
+		Endpoint:    srv.URL + "/v1/metrics",
+		ServiceName: "svc",
+		Backoff:     time.Millisecond,
+	})
+	defer o.Close()
+
+	r.IncrDeltaSyncSuffix("reqs", 5, "a")
+	r.NewValue("temp").Set(21.5)
+
+	d := r.NewDistribution("lat")
+	d.Mark(1.0)
+	d.Mark(1113.0)
+	d.Mark(1113.0)
+
+	logAndFlush(t, r)
+
+	if calls.Load() != 2 {
+		t.Fatalf("Expected a retry after the 503 got %d calls", calls.Load())
+	}
+
+	if len(got.ResourceMetrics) != 1 {
+		t.Fatalf("Bad request %+v", got)
+	}
+
+	rm := got.ResourceMetrics[0]
+	attrs := map[string]otlpAnyValue{}
+
+	for _, kv := range rm.Resource.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+
+	if v := attrs["service.name"]; v.StringValue == nil || *v.StringValue != "svc" {
+		t.Errorf("Expected service.name svc got %v", v)
+	}
+
+	if attrs["process.pid"].IntValue != strconv.Itoa(os.Getpid()) {
+		t.Errorf("Expected the pid got %v", attrs["process.pid"])
+	}
+
+	metrics := map[string]otlpMetric{}
+	for _, m := range rm.ScopeMetrics[0].Metrics {
+		metrics[m.Name] = m
+	}
+
+	if s := metrics["reqs"].Sum; s == nil || s.AggregationTemporality != otlpCumulative || s.IsMonotonic ||
+		s.DataPoints[0].AsInt != "5" || s.DataPoints[0].StartTimeUnixNano == "" {
+		t.Errorf("Expected a non monotonic cumulative sum of 5 got %+v", metrics["reqs"])
+	}
+
+	if g := metrics["temp"].Gauge; g == nil || *g.DataPoints[0].AsDouble != 21.5 {
+		t.Errorf("Expected a gauge of 21.5 got %+v", metrics["temp"])
+	}
+
+	if metrics["lat"].Unit != "seconds" {
+		t.Errorf("Expected the unit seconds got %q", metrics["lat"].Unit)
+	}
+
+	h := metrics["lat"].Histogram
+	if h == nil {
+		t.Fatalf("Expected a histogram got %+v", metrics["lat"])
+	}
+
+	p := h.DataPoints[0]
+	if p.Count != "3" || len(p.ExplicitBounds) != 2 || p.ExplicitBounds[0] != 1.1 || p.ExplicitBounds[1] != 1200 ||
+		len(p.BucketCounts) != 3 || p.BucketCounts[0] != "1" || p.BucketCounts[1] != "2" || p.BucketCounts[2] != "0" {
+		t.Errorf("Bad histogram point %+v", p)
+	}
+}
+
+func TestOTLPNoRetryOnBadRequest(t *testing.T) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		calls.Add(1)
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer srv.Close()
+
+	r := NewRegistry()
+	o := r.NewOTLP(OTLPOptions{Endpoint: srv.URL, Backoff: time.Millisecond})
+
+	defer o.Close()
+
+	if err := o.Report(context.Background(), &Report{Time: time.Now()}); !errors.Is(err, errOTLPStatus) {
+		t.Errorf("Expected errOTLPStatus got %v", err)
+	}
+
+	if calls.Load() != 1 {
+		t.Errorf("Expected no retries got %d calls", calls.Load())
+	}
+}
+
+// TestOTLPMixed checks increments with no suffix or labels next to
+// labeled ones of the same name aren't lost.
+func TestOTLPMixed(t *testing.T) {
+	r := NewRegistry()
+	tr := &testReporter{}
+
+	r.AddReporter(tr)
+	r.IncrDeltaSyncSuffix("mixed", 10, "")
+	r.IncrDeltaSyncLabels("mixed", 1, L("code", "500"))
+	r.MarkDistributionSyncSuffix("lat", 5, "a") // before PerCaller
+	r.SetSuffixMode("lat", PerCaller)
+	r.MarkDistributionSyncSuffix("lat", 5, "a")
+	logAndFlush(t, r)
+
+	o := &OTLP{r: r}
+	req := o.request(tr.reports[0])
+	got := map[string]string{}
+
+	for _, m := range req.ResourceMetrics[0].ScopeMetrics[0].Metrics {
+		switch {
+		case m.Name == "mixed":
+			for _, p := range m.Sum.DataPoints {
+				got[m.Name+fmt.Sprint(len(p.Attributes))] = p.AsInt
+			}
+		case m.Histogram != nil:
+			for _, p := range m.Histogram.DataPoints {
+				got[m.Name+fmt.Sprint(len(p.Attributes))] = p.Count
+			}
+		}
+	}
+
+	want := map[string]string{"mixed0": "10", "mixed1": "1", "lat0": "1", "lat1": "1"}
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("Expected %s for %s got %v", n, k, got)
+		}
+	}
+}
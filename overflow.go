@@ -0,0 +1,94 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this overflow.go file decides what the async API does when one of
+// the channels is full and keeps count of what got dropped.
+
+import (
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy picks what Incr/Set etc. do when the channel they
+// picked is full.
+type OverflowPolicy int32
+
+const (
+	// OverflowDrop throws the message away (the original behavior).
+	OverflowDrop OverflowPolicy = iota
+	// OverflowBlock waits for room in the channel.
+	OverflowBlock
+	// OverflowBlockTimeout waits for room up to the timeout given to
+	// SetOverflowPolicy and then drops.
+	OverflowBlockTimeout
+	// OverflowSync does the increment/set synchronously instead, like
+	// IncrSync.
+	OverflowSync
+)
+
+// SetOverflowPolicy sets what the async API does with a full channel;
+// timeout is only used by OverflowBlockTimeout.  Every drop is counted
+// in a per channel 0_counters_dropped_chN or 0_values_dropped_chN
+// counter.
+func (r *Registry) SetOverflowPolicy(p OverflowPolicy, timeout time.Duration) {
+	r.overflowTimeout.Store(int64(timeout))
+	r.overflowPolicy.Store(int32(p))
+}
+
+// offer is the slow path once a send to ch found it full; it returns
+// false if the message ended up dropped.
+func offer[M any](r *Registry, ch chan M, m M, sync func(M)) bool {
+	switch OverflowPolicy(r.overflowPolicy.Load()) {
+	case OverflowBlock:
+		select {
+		case ch <- m:
+			return true
+		case <-r.finished:
+		}
+	case OverflowBlockTimeout:
+		t := time.NewTimer(time.Duration(r.overflowTimeout.Load()))
+		defer t.Stop()
+
+		select {
+		case ch <- m:
+			return true
+		case <-t.C:
+		case <-r.finished:
+		}
+	case OverflowSync:
+		sync(m)
+
+		return true
+	case OverflowDrop:
+	}
+
+	return false
+}
+
+func (r *Registry) overflowCounter(j uint32, cm counterMsg) {
+	if !offer(r, r.c[j], cm, r.applyCounter) {
+		atomic.AddInt64(&r.counterDrops[j], 1)
+	}
+}
+
+func (r *Registry) overflowValue(j uint32, vm valueMsg) {
+	if !offer(r, r.v[j], vm, r.applyValue) {
+		atomic.AddInt64(&r.valueDrops[j], 1)
+	}
+}
+
+// countDrops moves the drops since last time into the drop counters
+// so they get logged and reported alongside the others.
+func (r *Registry) countDrops() {
+	for j := range numChannels {
+		if d := atomic.SwapInt64(&r.counterDrops[j], 0); d != 0 {
+			r.getOrMakeAndIncrCounter("0_counters_dropped_ch"+strconv.Itoa(j), "counters", d)
+		}
+
+		if d := atomic.SwapInt64(&r.valueDrops[j], 0); d != 0 {
+			r.getOrMakeAndIncrCounter("0_values_dropped_ch"+strconv.Itoa(j), "counters", d)
+		}
+	}
+}
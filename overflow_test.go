@@ -0,0 +1,120 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+// newFullRegistry returns a Registry with tiny channels and no
+// reading go routines so the overflow paths are easy to hit.
+func newFullRegistry(size int) *Registry {
+	r := &Registry{}
+	r.initCtx()
+
+	for i := range numChannels {
+		r.c[i] = make(chan counterMsg, size)
+		r.v[i] = make(chan valueMsg, size)
+	}
+
+	r.accepting.Store(true)
+
+	return r
+}
+
+func sumDrops(r *Registry, prefix string) int64 {
+	total := int64(0)
+
+	for j := range numChannels {
+		name := prefix + strconv.Itoa(j)
+
+		if c, ok := r.countersByName.get(name); ok {
+			total += c.load()
+		}
+	}
+
+	return total
+}
+
+func TestOverflowDropIsCounted(t *testing.T) {
+	r := newFullRegistry(1)
+
+	for range 30 {
+		r.IncrSuffix("overflowing", "test")
+	}
+
+	for range 30 {
+		r.SetSuffix("overflowing_val", 1.0, "test")
+	}
+
+	r.LogCounters()
+
+	if got := sumDrops(r, "0_counters_dropped_ch"); got != 20 {
+		t.Errorf("Expected 20 counter drops, got %d", got)
+	}
+
+	if got := sumDrops(r, "0_values_dropped_ch"); got != 20 {
+		t.Errorf("Expected 20 value drops, got %d", got)
+	}
+}
+
+func TestOverflowSync(t *testing.T) {
+	r := newFullRegistry(1)
+	r.SetOverflowPolicy(OverflowSync, 0)
+
+	for range 30 {
+		r.IncrSuffix("overflowing", "test")
+	}
+
+	if got := r.ReadSync("overflowing"); got != 20 {
+		t.Errorf("Expected 20 done synchronously, got %d", got)
+	}
+}
+
+func TestOverflowBlockTimeout(t *testing.T) {
+	r := newFullRegistry(0)
+	r.SetOverflowPolicy(OverflowBlockTimeout, 10*time.Millisecond)
+
+	start := time.Now()
+
+	r.IncrSuffix("overflowing", "test")
+
+	if time.Since(start) < 10*time.Millisecond {
+		t.Errorf("Expected to wait for the timeout")
+	}
+
+	r.countDrops()
+
+	if got := sumDrops(r, "0_counters_dropped_ch"); got != 1 {
+		t.Errorf("Expected 1 drop after timeout, got %d", got)
+	}
+}
+
+func TestOverflowBlock(t *testing.T) {
+	r := newFullRegistry(0)
+	r.SetOverflowPolicy(OverflowBlock, 0)
+
+	got := make(chan counterMsg, 1)
+
+	go func() {
+		for {
+			for j := range numChannels {
+				select {
+				case cm := <-r.c[j]:
+					got <- cm
+
+					return
+				default:
+				}
+			}
+		}
+	}()
+
+	r.IncrDeltaSuffix("blocking", 7, "test")
+
+	if cm := <-got; cm.i != 7 {
+		t.Errorf("Expected the blocked message to arrive, got %v", cm)
+	}
+}
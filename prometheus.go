@@ -0,0 +1,301 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"bufio"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// this prometheus.go file renders the registry in the Prometheus
+// text exposition format 0.0.4 for scraping: counters as counters,
+// values and meta counters as gauges and distributions as
+// histograms.  openmetrics.go has the OpenMetrics differences.
+
+const promContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// promSample is one line of a family, e.g. the name_bucket ones of a
+// histogram.
+type promSample struct {
+	suffix   string // e.g. _bucket, _count
+	labels   []Label
+	value    float64
+	exemplar *Exemplar // OpenMetrics only
+}
+
+// promFamily is the samples under one # TYPE line.
+type promFamily struct {
+	name    string
+	typ     string // counter, gauge or histogram
+	unit    string // see SetUnit
+	samples []promSample
+}
+
+// PrometheusHandler returns an http.Handler serving the registry in
+// the Prometheus text format, or OpenMetrics if the scraper's Accept
+// header prefers it.  A PerCaller suffix is the label suffix.  If a
+// counter or distribution has suffix or label breakdowns, the series
+// with neither is only what they don't cover, so the series add up to
+// the merged total.  A value's series with neither is its merged
+// value, which overlaps the breakdowns.  Distributions have no _sum
+// as the marked values aren't kept.
+func (r *Registry) PrometheusHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		om := wantsOpenMetrics(req.Header.Get("Accept"))
+
+		if om {
+			w.Header().Set("Content-Type", omContentType)
+		} else {
+			w.Header().Set("Content-Type", promContentType)
+		}
+
+		bw := bufio.NewWriter(w)
+		writePrometheus(bw, promFamilies(r.Snapshot(), r.unitsCopy(), om), om)
+		_ = bw.Flush()
+	})
+}
+
+// promFamilies turns a snapshot into families sorted by name.
+func promFamilies(s RegistrySnapshot, units map[string]string, om bool) []*promFamily {
+	created := float64(s.Start.UnixNano()) / 1e9 //nolint:mnd
+
+	fams := map[string]*promFamily{}
+	family := func(name string, typ string) *promFamily {
+		unit := units[name]
+		name = promName(name)
+
+		if om && typ == "counter" {
+			name = strings.TrimSuffix(name, "_total")
+		}
+
+		if unit != "" && !strings.HasSuffix(name, "_"+unit) {
+			name += "_" + unit
+		}
+
+		f, ok := fams[name]
+		if !ok {
+			f = &promFamily{name: name, typ: typ, unit: unit}
+			fams[name] = f
+		}
+
+		return f
+	}
+
+	s = s.exported()
+
+	for _, c := range s.Counters {
+		f := family(c.Base, "counter")
+		labels := promLabels(c.Suffix, c.Labels)
+
+		if om {
+			f.samples = append(f.samples,
+				promSample{suffix: "_total", labels: labels, value: float64(c.Total)},
+				promSample{suffix: "_created", labels: labels, value: created},
+			)
+		} else {
+			f.samples = append(f.samples, promSample{labels: labels, value: float64(c.Total)})
+		}
+	}
+
+	for _, v := range s.Values {
+		f := family(v.Base, "gauge")
+		f.samples = append(f.samples, promSample{labels: promLabels(v.Suffix, v.Labels), value: v.Value})
+	}
+
+	for _, m := range s.Meta {
+		name, suffix, _ := strings.Cut(m.Name, "/")
+		f := family(name, "gauge")
+		f.samples = append(f.samples, promSample{labels: promLabels(suffix, nil), value: m.Total})
+	}
+
+	for _, d := range s.Distributions {
+		f := family(d.Name, "histogram")
+		f.samples = append(f.samples, histogramSamples(d)...)
+
+		if om {
+			f.samples = append(f.samples, promSample{suffix: "_created", labels: promLabels(d.Suffix, d.Labels), value: created})
+		}
+	}
+
+	res := make([]*promFamily, 0, len(fams))
+
+	for _, f := range fams {
+		res = append(res, f)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].name < res[j].name })
+
+	return res
+}
+
+// histogramSamples makes the cumulative le buckets and count for a
+// distribution; buckets whose range isn't known only count in +Inf.
+func histogramSamples(d DistributionSnapshot) []promSample {
+	labels := promLabels(d.Suffix, d.Labels)
+
+	buckets := append([]BucketSnapshot(nil), d.Buckets...)
+	sort.SliceStable(buckets, func(i, j int) bool { return buckets[i].Upper < buckets[j].Upper })
+
+	res := []promSample{}
+	total, unknown := int64(0), int64(0)
+	lastLE := ""
+
+	var unknownEx *Exemplar // from buckets only in +Inf
+
+	for _, b := range buckets {
+		if !b.Known {
+			unknown += b.Total
+			unknownEx = newerExemplar(unknownEx, b.Exemplar)
+
+			continue
+		}
+
+		total += b.Total
+
+		le := formatFloat(b.Upper)
+		if le == lastLE { // only one line per bound
+			res[len(res)-1].value = float64(total)
+			res[len(res)-1].exemplar = newerExemplar(res[len(res)-1].exemplar, b.Exemplar)
+
+			continue
+		}
+
+		lastLE = le
+		res = append(res, promSample{
+			suffix:   "_bucket",
+			labels:   append(append([]Label(nil), labels...), L("le", le)),
+			value:    float64(total),
+			exemplar: b.Exemplar,
+		})
+	}
+
+	total += unknown
+	inf := append(append([]Label(nil), labels...), L("le", "+Inf"))
+	res = append(res,
+		promSample{suffix: "_bucket", labels: inf, value: float64(total), exemplar: unknownEx},
+		promSample{suffix: "_count", labels: labels, value: float64(total)},
+	)
+
+	return res
+}
+
+// promLabels is the suffix label, if any, then the labels.
+func promLabels(suffix string, labels []Label) []Label {
+	res := make([]Label, 0, len(labels)+1)
+
+	if suffix != "" {
+		res = append(res, L("suffix", suffix))
+	}
+
+	return append(res, labels...)
+}
+
+// writePrometheus writes the families in the Prometheus text format
+// or, with om, OpenMetrics.
+func writePrometheus(w *bufio.Writer, fams []*promFamily, om bool) {
+	for _, f := range fams {
+		w.WriteString("# TYPE " + f.name + " " + f.typ + "\n")
+
+		if om && f.unit != "" {
+			w.WriteString("# UNIT " + f.name + " " + f.unit + "\n")
+		}
+
+		for _, s := range f.samples {
+			writePromSample(w, f.name+s.suffix, s.labels, s.value)
+
+			if om && s.exemplar != nil {
+				writeExemplar(w, s.exemplar)
+			}
+
+			w.WriteByte('\n')
+		}
+	}
+
+	if om {
+		w.WriteString("# EOF\n")
+	}
+}
+
+// writePromSample writes name{labels} value without the newline.
+func writePromSample(w *bufio.Writer, name string, labels []Label, v float64) {
+	w.WriteString(name)
+	writePromLabels(w, labels)
+	w.WriteString(" " + formatFloat(v))
+}
+
+// writePromLabels writes {k="v",...} if there are any labels.
+func writePromLabels(w *bufio.Writer, labels []Label) {
+	if len(labels) == 0 {
+		return
+	}
+
+	w.WriteByte('{')
+
+	for i, l := range labels {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+
+		w.WriteString(promLabelName(l.Key) + `="` + promEscape(l.Value) + `"`)
+	}
+
+	w.WriteByte('}')
+}
+
+// promName makes name a valid metric name: [a-zA-Z_:][a-zA-Z0-9_:]*
+// with anything else an underscore, e.g. 0_gc is _0_gc.
+func promName(name string) string {
+	return sanitize(name, true)
+}
+
+// promLabelName is promName without colons.
+func promLabelName(name string) string {
+	return sanitize(name, false)
+}
+
+func sanitize(name string, colons bool) string {
+	var sb strings.Builder
+
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', colons && c == ':':
+			sb.WriteRune(c)
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+
+			sb.WriteRune(c)
+		default:
+			sb.WriteByte('_')
+		}
+	}
+
+	if sb.Len() == 0 {
+		return "_"
+	}
+
+	return sb.String()
+}
+
+// promEscape escapes a label value.
+func promEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
@@ -0,0 +1,151 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestPrometheusHandler(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+	r.SetSuffixMode("http.requests", PerCaller)
+
+	r.IncrDeltaSyncSuffix("http.requests", 3, "main")
+	r.IncrDeltaSyncSuffix("http.requests", 2, `we"ird`)
+	r.IncrDeltaSyncSuffix("0_plain", 7, "a")
+	r.NewValue("temp").Set(21.5)
+	r.IncrDeltaSyncSuffix("good", 3, "a")
+	r.IncrDeltaSyncSuffix("bad", 1, "a")
+	r.AddMetaCounter("avail", "good", "bad", RatioTotal)
+
+	d := r.NewDistribution("latency")
+	d.Mark(1.0)
+	d.Mark(1113.0)
+	d.Mark(1113.0)
+	d.Mark(0)
+
+	rec := httptest.NewRecorder()
+	r.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Bad content type %s", ct)
+	}
+
+	body, _ := io.ReadAll(rec.Body)
+	out := string(body)
+
+	for _, want := range []string{
+		"# TYPE http_requests counter\n",
+		`http_requests{suffix="main"} 3` + "\n",
+		`http_requests{suffix="we\"ird"} 2` + "\n",
+		"_0_plain 7\n",
+		"# TYPE temp gauge\ntemp 21.5\n",
+		"avail 0.75\n",
+		"# TYPE latency histogram\n",
+		`latency_bucket{le="0"} 1` + "\n",
+		`latency_bucket{le="1.1"} 2` + "\n",
+		`latency_bucket{le="1200"} 4` + "\n",
+		`latency_bucket{le="+Inf"} 4` + "\n",
+		"latency_count 4\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+
+	if strings.Contains(out, "http_requests 5") {
+		t.Errorf("Expected the merged total left out with a breakdown\n%s", out)
+	}
+}
+
+// TestPrometheusMixed checks increments with no suffix or labels
+// next to labeled ones of the same name aren't lost.
+func TestPrometheusMixed(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	r.IncrDeltaSyncSuffix("mixed", 10, "")
+	r.IncrDeltaSyncLabels("mixed", 1, L("code", "500"))
+	r.SetLabels("temp", 2, L("room", "a"))
+	r.NewDistribution("lat").Mark(5)
+	r.MarkDistributionLabels("lat", 5, L("code", "500"))
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+
+	r.NewValue("temp").Set(3) // the merged value, set after the labeled one
+
+	rec := httptest.NewRecorder()
+	r.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		"mixed 10\n",
+		`mixed{code="500"} 1` + "\n",
+		"temp 3\n",
+		`temp{room="a"} 2` + "\n",
+		"lat_count 1\n",
+		`lat_count{code="500"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+}
+
+// TestPrometheusOutOfRange checks a sample in a bucket whose range
+// isn't known only counts in +Inf, not in the le buckets above it.
+func TestPrometheusOutOfRange(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	d := r.NewDistribution("wide")
+	d.Mark(1e16)
+	d.Mark(0.002)
+	d.Mark(5)
+
+	rec := httptest.NewRecorder()
+	r.PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+
+	out := rec.Body.String()
+
+	for _, want := range []string{
+		`wide_bucket{le="0.0021"} 1` + "\n",
+		`wide_bucket{le="5.1"} 2` + "\n",
+		`wide_bucket{le="+Inf"} 3` + "\n",
+		"wide_count 3\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestPromName(t *testing.T) {
+	for name, want := range map[string]string{
+		"ok_name:x":          "ok_name:x",
+		"0_gc_heap":          "_0_gc_heap",
+		"a-b[1.1k]/c d":      "a_b_1_1k__c_d",
+		"":                   "_",
+		"dist-g[001k-2k]":    "dist_g_001k_2k_",
+		"http.requests.2xx":  "http_requests_2xx",
+		"café":               "caf_",
+		"with{k=v}":          "with_k_v_",
+		"TimeFunc:chris":     "TimeFunc:chris",
+		"endsWithDigit9":     "endsWithDigit9",
+		"9starts":            "_9starts",
+		"_already_prefixed":  "_already_prefixed",
+		"UPPER_lower_123_ok": "UPPER_lower_123_ok",
+	} {
+		if got := promName(name); got != want {
+			t.Errorf("Expected %s for %s got %s", want, name, got)
+		}
+	}
+}
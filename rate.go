@@ -0,0 +1,62 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// this rate.go file works out each counter's per second rate for the
+// interval and 1, 5 and 15 minute moving averages of it, like the
+// unix load average, so counters can be compared whatever the log
+// interval.
+
+// ewma is a counter's moving average rates per second.  Guarded by
+// ctxLock.
+type ewma struct {
+	rate1  float64
+	rate5  float64
+	rate15 float64
+	primed bool // false until the first interval has been seen
+}
+
+// update folds in delta over elapsed and returns the interval's rate
+// per second.
+func (e *ewma) update(delta int64, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+
+	rate := float64(delta) / elapsed.Seconds()
+
+	if !e.primed {
+		e.rate1, e.rate5, e.rate15, e.primed = rate, rate, rate, true
+
+		return rate
+	}
+
+	e.rate1 += alpha(elapsed, time.Minute) * (rate - e.rate1)
+	e.rate5 += alpha(elapsed, 5*time.Minute) * (rate - e.rate5)    //nolint:mnd
+	e.rate15 += alpha(elapsed, 15*time.Minute) * (rate - e.rate15) //nolint:mnd
+
+	return rate
+}
+
+// alpha is how much an interval of elapsed counts in an average over
+// window.
+func alpha(elapsed time.Duration, window time.Duration) float64 {
+	return 1 - math.Exp(-elapsed.Seconds()/window.Seconds())
+}
+
+// rateColumns formats the rates as log columns.
+func rateColumns(rate float64, e *ewma) string {
+	return fmt.Sprintf(" %12.3f %12.3f %12.3f %12.3f", rate, e.rate1, e.rate5, e.rate15)
+}
+
+// noRateColumns lines values up with the counters' rate columns.
+var noRateColumns = fmt.Sprintf(" %12s %12s %12s %12s", "", "", "", "")
+
+// rateHeader is the names of the rate columns.
+var rateHeader = fmt.Sprintf(" %12s %12s %12s %12s", "rate/s", "1m rate", "5m rate", "15m rate")
@@ -0,0 +1,70 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestEWMA(t *testing.T) {
+	e := ewma{}
+
+	if got := e.update(600, time.Minute); got != 10 {
+		t.Errorf("Expected rate 10 got %f", got)
+	}
+
+	if e.rate1 != 10 || e.rate15 != 10 {
+		t.Errorf("Expected the first interval to prime the averages got %v", e)
+	}
+
+	e.update(0, time.Minute)
+
+	if want := 10 * math.Exp(-1); math.Abs(e.rate1-want) > 1e-9 {
+		t.Errorf("Expected 1m rate %f got %f", want, e.rate1)
+	}
+
+	if e.rate5 <= e.rate1 || e.rate15 <= e.rate5 {
+		t.Errorf("Expected the longer averages to decay slower got %v", e)
+	}
+
+	// the same decay over the same time whatever the interval
+	f := ewma{}
+	f.update(60, 6*time.Second)
+
+	for range 10 {
+		f.update(0, 6*time.Second)
+	}
+
+	if math.Abs(f.rate1-e.rate1) > 1e-9 {
+		t.Errorf("Expected %f with a shorter interval got %f", e.rate1, f.rate1)
+	}
+
+	if e.update(5, 0) != 0 {
+		t.Errorf("Expected no rate with no elapsed time")
+	}
+}
+
+func TestRateReports(t *testing.T) {
+	r := NewRegistry()
+
+	var got MetricReport
+
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		for _, m := range metrics {
+			if m.Name == "rated" {
+				got = m
+			}
+		}
+	})
+
+	r.IncrDeltaSyncSuffix("rated", 100, "a")
+	time.Sleep(10 * time.Millisecond)
+	logAndFlush(t, r)
+
+	want := 100 / got.Interval.Seconds()
+	if got.Interval <= 0 || math.Abs(got.Rate-want) > 1e-6 || got.Rate1 != got.Rate {
+		t.Errorf("Expected rate %f got %v", want, got)
+	}
+}
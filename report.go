@@ -0,0 +1,348 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"slices"
+	"sort"
+	"time"
+)
+
+// this report.go file has the Reporter interface; every registered
+// Reporter gets one combined Report each interval.  SetMetricReporter
+// and SetValReporter are Reporters underneath.
+
+// Report is everything logged for one interval.  Distribution
+// buckets are only in Distributions, not Counters.  A Report is
+// shared between the Reporters so must not be changed.
+type Report struct {
+	Time          time.Time
+	Start         time.Time     // when InitCounters was called
+	Interval      time.Duration // the time actually covered by the deltas
+	Counters      []MetricReport
+	Values        []ValReport
+	Meta          []MetaSnapshot
+	Distributions []DistributionReport
+}
+
+// DistributionReport is one distribution's buckets, sorted by Lower.
+// There is one per suffix or label set as well as the merged one.
+type DistributionReport struct {
+	Name    string
+	Suffix  string
+	Labels  []Label
+	Buckets []BucketReport
+}
+
+// BucketReport is one bucket of a distribution; the embedded
+// MetricReport is for the bucket's counter, e.g. Name is
+// test-g[001.1k-1.2k] and Bucket 001.1k-1.2k.
+type BucketReport struct {
+	MetricReport
+	Bucket string
+	Lower  float64
+	Upper  float64
+	Known  bool // false if Lower and Upper couldn't be worked out
+}
+
+// Reporter is a sink for the Report made each interval, see
+// AddReporter.
+type Reporter interface {
+	Report(ctx context.Context, rep *Report) error
+}
+
+// AddReporter adds rep to the Reporters called each interval, with
+// the default ReporterOptions.  rep must be comparable (e.g. a
+// pointer) to be removed again.
+func (r *Registry) AddReporter(rep Reporter) {
+	r.AddReporterOptions(rep, ReporterOptions{})
+}
+
+// AddReporterOptions adds rep to the Reporters called each interval.
+// Each Reporter is called on its own go routine as set out by opts.
+func (r *Registry) AddReporterOptions(rep Reporter, opts ReporterOptions) {
+	r.ctxLock.Lock()
+	r.reporters = append(r.reporters, newReporterRunner(rep, opts))
+	r.ctxLock.Unlock()
+}
+
+// RemoveReporter removes rep, as passed to AddReporter.
+func (r *Registry) RemoveReporter(rep Reporter) {
+	r.ctxLock.Lock()
+	r.removeReporter(rep)
+	r.ctxLock.Unlock()
+}
+
+// removeReporterWait is RemoveReporter then waits for rep's go
+// routine to deliver what is queued and exit, so rep can be closed.
+func (r *Registry) removeReporterWait(rep Reporter) {
+	r.ctxLock.Lock()
+	dones := r.removeReporter(rep)
+	r.ctxLock.Unlock()
+
+	for _, done := range dones {
+		<-done
+	}
+}
+
+// removeReporter stops rep's go routine once it has delivered what is
+// queued, returning the channels closed when it has.  Must hold
+// ctxLock.
+func (r *Registry) removeReporter(rep Reporter) []chan struct{} {
+	dones := []chan struct{}{}
+
+	r.reporters = slices.DeleteFunc(r.reporters, func(x *reporterRunner) bool {
+		if x.rep != rep {
+			return false
+		}
+
+		if done := x.stop(true); done != nil {
+			dones = append(dones, done)
+		}
+
+		return true
+	})
+
+	return dones
+}
+
+// metricReporter adapts a MetricReporter; the bucket counters are
+// put back in with the others as they were before Reporter.
+type metricReporter struct {
+	fn MetricReporter
+}
+
+func (m *metricReporter) Report(_ context.Context, rep *Report) error {
+	metrics := append([]MetricReport(nil), rep.Counters...)
+
+	for _, d := range rep.Distributions {
+		for _, b := range d.Buckets {
+			metrics = append(metrics, b.MetricReport)
+		}
+	}
+
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].Name < metrics[j].Name })
+	m.fn(metrics)
+
+	return nil
+}
+
+// valReporter adapts a ValReporter.
+type valReporter struct {
+	fn ValReporter
+}
+
+func (v *valReporter) Report(_ context.Context, rep *Report) error {
+	v.fn(rep.Values)
+
+	return nil
+}
+
+// SetMetricReporter specifies a function to be called once per
+// LogInterval with the names of the current metrics and the last
+// minute delta.  It replaces the last one set; use AddReporter for
+// more than one sink.
+func (r *Registry) SetMetricReporter(fn MetricReporter) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	if r.logCb != nil {
+		r.removeReporter(r.logCb)
+		r.logCb = nil
+	}
+
+	if fn != nil {
+		r.logCb = &metricReporter{fn}
+		r.reporters = append(r.reporters, newReporterRunner(r.logCb, ReporterOptions{}))
+	}
+}
+
+// SetValReporter specifies a function to be called once per
+// LogInterval with the names of the current metrics which are
+// float64s and the last minute delta.  It replaces the last one set;
+// use AddReporter for more than one sink.
+func (r *Registry) SetValReporter(fn ValReporter) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	if r.valCb != nil {
+		r.removeReporter(r.valCb)
+		r.valCb = nil
+	}
+
+	if fn != nil {
+		r.valCb = &valReporter{fn}
+		r.reporters = append(r.reporters, newReporterRunner(r.valCb, ReporterOptions{}))
+	}
+}
+
+// bucketGroup is the buckets of one distribution and suffix or
+// label set.
+type bucketGroup[B any] struct {
+	dist    string
+	suffix  string
+	labels  []Label
+	buckets []B
+}
+
+// bucketGroups collects bucket counters into their distributions,
+// for Snapshot and Report.
+type bucketGroups[B any] struct {
+	keys   []string
+	groups map[string]*bucketGroup[B]
+}
+
+func (g *bucketGroups[B]) add(info *bucketInfo, c *counter, b B) {
+	key := labelKey(info.dist, c.labels)
+	if c.suffix != "" {
+		key += "/" + c.suffix
+	}
+
+	if g.groups == nil {
+		g.groups = make(map[string]*bucketGroup[B])
+	}
+
+	bg, ok := g.groups[key]
+	if !ok {
+		bg = &bucketGroup[B]{dist: info.dist, suffix: c.suffix, labels: c.labels}
+		g.groups[key] = bg
+		g.keys = append(g.keys, key)
+	}
+
+	bg.buckets = append(bg.buckets, b)
+}
+
+// sorted returns the groups by name with their buckets by lower
+// bound.
+func (g *bucketGroups[B]) sorted(lower func(B) float64) []*bucketGroup[B] {
+	sort.Strings(g.keys)
+
+	res := make([]*bucketGroup[B], 0, len(g.keys))
+
+	for _, k := range g.keys {
+		bg := g.groups[k]
+		sort.SliceStable(bg.buckets, func(i, j int) bool { return lower(bg.buckets[i]) < lower(bg.buckets[j]) })
+		res = append(res, bg)
+	}
+
+	return res
+}
+
+// isBreakdown is whether a metric with suffix and labels is a
+// breakdown of its name's merged one.
+func isBreakdown(suffix string, labels []Label) bool {
+	return suffix != "" || len(labels) > 0
+}
+
+// restEntry is a counter's (or a bucket's) total and delta for
+// lessBreakdowns; key is the name, or the name and bucket.
+type restEntry struct {
+	key    string
+	broken bool // a breakdown, not the merged one
+	total  *int64
+	delta  *int64
+}
+
+// lessBreakdowns returns a copy of xs with each merged entry less
+// what its breakdowns add up to, leaving what only it counted:
+// increments with no suffix or labels, or made before SetSuffixMode.
+// Those left with nothing are dropped.  of gives x's entries, after
+// copying anything it changes that xs shares.
+func lessBreakdowns[T any](xs []T, of func(x *T) []restEntry) []T {
+	xs = slices.Clone(xs)
+
+	es, owner := []restEntry{}, []int{}
+	left := make([]bool, len(xs)) // whether to keep each x
+
+	for i := range xs {
+		xes := of(&xs[i])
+		left[i] = len(xes) == 0
+
+		for _, e := range xes {
+			es = append(es, e)
+			owner = append(owner, i)
+		}
+	}
+
+	sums := map[string][2]int64{}
+
+	for _, e := range es {
+		if e.broken {
+			s := sums[e.key]
+			sums[e.key] = [2]int64{s[0] + *e.total, s[1] + *e.delta}
+		}
+	}
+
+	for j, e := range es {
+		if s, ok := sums[e.key]; ok && !e.broken {
+			*e.total -= s[0]
+			*e.delta -= s[1]
+
+			if *e.total == 0 && *e.delta == 0 {
+				continue
+			}
+		}
+
+		left[owner[j]] = true
+	}
+
+	res := make([]T, 0, len(xs))
+
+	for i, x := range xs {
+		if left[i] {
+			res = append(res, x)
+		}
+	}
+
+	return res
+}
+
+// exported is s as the exporters show it: a name's merged counter
+// and distribution are only what its suffix or label breakdowns
+// don't cover, so the series add up to the merged total without
+// counting anything twice.  Only Total and Delta change, and values
+// are left as they are as they don't add up.
+func (s RegistrySnapshot) exported() RegistrySnapshot {
+	s.Counters = lessBreakdowns(s.Counters, func(c *CounterSnapshot) []restEntry {
+		return []restEntry{{c.Base, isBreakdown(c.Suffix, c.Labels), &c.Total, &c.Delta}}
+	})
+
+	s.Distributions = lessBreakdowns(s.Distributions, func(d *DistributionSnapshot) []restEntry {
+		d.Buckets = slices.Clone(d.Buckets)
+		es := make([]restEntry, len(d.Buckets))
+
+		for i := range d.Buckets {
+			b := &d.Buckets[i]
+			es[i] = restEntry{d.Name + "\x00" + b.Bucket, isBreakdown(d.Suffix, d.Labels), &b.Total, &b.Delta}
+		}
+
+		return es
+	})
+
+	return s
+}
+
+// exported is rep as the exporters show it, see
+// RegistrySnapshot.exported; rep itself is shared so isn't changed.
+func (rep *Report) exported() *Report {
+	res := *rep
+
+	res.Counters = lessBreakdowns(rep.Counters, func(c *MetricReport) []restEntry {
+		return []restEntry{{c.Base, isBreakdown(c.Suffix, c.Labels), &c.Total, &c.Delta}}
+	})
+
+	res.Distributions = lessBreakdowns(rep.Distributions, func(d *DistributionReport) []restEntry {
+		d.Buckets = slices.Clone(d.Buckets)
+		es := make([]restEntry, len(d.Buckets))
+
+		for i := range d.Buckets {
+			b := &d.Buckets[i]
+			es[i] = restEntry{d.Name + "\x00" + b.Bucket, isBreakdown(d.Suffix, d.Labels), &b.Total, &b.Delta}
+		}
+
+		return es
+	})
+
+	return &res
+}
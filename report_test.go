@@ -0,0 +1,98 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"testing"
+)
+
+type testReporter struct {
+	reports []*Report
+}
+
+func (tr *testReporter) Report(_ context.Context, rep *Report) error {
+	tr.reports = append(tr.reports, rep)
+
+	return nil
+}
+
+func TestReporters(t *testing.T) {
+	r := NewRegistry()
+	a, b := &testReporter{}, &testReporter{}
+
+	r.AddReporter(a)
+	r.AddReporter(b)
+
+	r.IncrDeltaSyncSuffix("rep_good", 3, "a")
+	r.IncrDeltaSyncSuffix("rep_bad", 1, "a")
+	r.AddMetaCounter("rep_avail", "rep_good", "rep_bad", RatioTotal)
+	r.NewDistribution("rep_dist").Mark(5)
+	logAndFlush(t, r)
+
+	r.RemoveReporter(b)
+	logAndFlush(t, r)
+
+	if len(a.reports) != 2 || len(b.reports) != 1 {
+		t.Fatalf("Expected 2 and 1 reports got %d %d", len(a.reports), len(b.reports))
+	}
+
+	rep := a.reports[0]
+	if rep.Interval <= 0 || rep.Start.IsZero() {
+		t.Errorf("Expected interval metadata got %v %v", rep.Interval, rep.Start)
+	}
+
+	if len(rep.Meta) != 1 || rep.Meta[0].Total != 0.75 {
+		t.Errorf("Expected rep_avail 0.75 got %v", rep.Meta)
+	}
+
+	for _, m := range rep.Counters {
+		if m.Name == "rep_good" && (m.Total != 3 || m.Delta != 3) {
+			t.Errorf("Expected total and delta 3 got %v", m)
+		}
+
+		if m.Name == "rep_distf[005.0-5.1]" {
+			t.Errorf("Expected the bucket only in Distributions")
+		}
+	}
+
+	if len(rep.Distributions) != 1 || len(rep.Distributions[0].Buckets) != 1 ||
+		rep.Distributions[0].Buckets[0].Total != 1 {
+		t.Errorf("Expected one rep_dist bucket got %v", rep.Distributions)
+	}
+}
+
+func TestSetMetricReporterReplaces(t *testing.T) {
+	r := NewRegistry()
+	first, second := 0, 0
+	buckets := 0
+
+	r.SetMetricReporter(func(_ []MetricReport) { first++ })
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		second++
+
+		for _, m := range metrics {
+			if m.Name == "rep_distf[005.0-5.1]" {
+				buckets++
+			}
+		}
+	})
+
+	r.NewDistribution("rep_dist").Mark(5)
+	logAndFlush(t, r)
+
+	if first != 0 || second != 1 {
+		t.Errorf("Expected only the second reporter called got %d %d", first, second)
+	}
+
+	if buckets != 1 {
+		t.Errorf("Expected bucket counters still passed to a MetricReporter")
+	}
+
+	r.SetMetricReporter(nil)
+	logAndFlush(t, r)
+
+	if second != 1 {
+		t.Errorf("Expected no calls after SetMetricReporter(nil)")
+	}
+}
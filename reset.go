@@ -0,0 +1,176 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this reset.go file lets counters and values be zeroed or removed,
+// by hand or once they have gone idle.  Counters and values with a
+// handle (see NewCounter) don't idle out, and a handle whose counter
+// is removed makes it again on its next use.
+
+// Remove deletes the counter, value or distribution name, its
+// name/suffix and label breakdowns and any meta counter called name.
+// Increments racing with Remove may be lost; later ones make it
+// again.
+func (r *Registry) Remove(name string) {
+	ctrs, vals := r.keysFor(name)
+
+	for _, k := range ctrs {
+		r.removeCounterKey(k)
+	}
+
+	for _, k := range vals {
+		r.removeValueKey(k)
+	}
+
+	r.ctxLock.Lock()
+	delete(r.metaCtrs, name)
+	r.ctxLock.Unlock()
+}
+
+// Reset zeroes the counter and/or value name and its breakdowns,
+// keeping them in place.
+func (r *Registry) Reset(name string) {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	ctrs, vals := r.keysFor(name)
+
+	for _, k := range ctrs {
+		r.resetCounterKey(k)
+	}
+
+	for _, k := range vals {
+		r.resetValueKey(k)
+	}
+}
+
+// ResetAll zeroes every counter and value.
+func (r *Registry) ResetAll() {
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	for _, e := range sortedEntries(r.countersByName, r.counters) {
+		e.v.zero()
+		e.v.oldData = 0
+		e.v.rollup = rollups{}
+		e.v.history = deltas{}
+		e.v.rates = ewma{}
+		e.v.exemplar.Store(nil)
+	}
+
+	for _, e := range sortedEntries(r.valuesByName, r.values) {
+		e.v.zero()
+		e.v.rollup = rollups{}
+		e.v.history = deltas{}
+	}
+}
+
+// SetIdleExpiry makes counters which have not changed, and values
+// which have not been set, for intervals log intervals in a row
+// disappear from the maps and from LogCounters; 0, the default, keeps
+// them forever.
+func (r *Registry) SetIdleExpiry(intervals int) {
+	r.ctxLock.Lock()
+	r.idleExpiry = intervals
+	r.ctxLock.Unlock()
+}
+
+// isIdle counts one more interval of idleness (or resets the count)
+// and says if it is time to expire.  Must hold ctxLock.
+func (r *Registry) isIdle(idle *int, unchanged bool) bool {
+	if !unchanged {
+		*idle = 0
+
+		return false
+	}
+
+	*idle++
+
+	return r.idleExpiry > 0 && *idle >= r.idleExpiry
+}
+
+// keysFor returns the keys in the counter maps and in the value maps
+// of name, its breakdowns and, if name is a distribution, its
+// buckets' counters; by the name they were made for so e.g. api/v1's
+// breakdowns aren't api's.
+func (r *Registry) keysFor(name string) ([]string, []string) {
+	ctrs, vals := []string{}, []string{}
+
+	of := func(base string) bool {
+		if base == name {
+			return true
+		}
+
+		b, ok := r.buckets.get(base)
+
+		return ok && b.dist == name
+	}
+
+	for _, e := range sortedEntries(r.countersByName, r.counters) {
+		if of(e.v.name) {
+			ctrs = append(ctrs, e.key)
+		}
+	}
+
+	for _, e := range sortedEntries(r.valuesByName, r.values) {
+		if e.v.name == name {
+			vals = append(vals, e.key)
+		}
+	}
+
+	return ctrs, vals
+}
+
+// removeCounterKey deletes key from the counter maps, and from the
+// buckets if it was a bucket's merged counter.
+func (r *Registry) removeCounterKey(key string) {
+	for _, m := range []*shardedMap[*counter]{r.countersByName, r.counters} {
+		if c, ok := m.delete(key); ok {
+			c.removed.Store(true)
+
+			if c.admitted {
+				r.release(key)
+			}
+		}
+	}
+
+	r.buckets.delete(key)
+}
+
+// removeValueKey deletes key from the value maps.
+func (r *Registry) removeValueKey(key string) {
+	for _, m := range []*shardedMap[*value]{r.valuesByName, r.values} {
+		if v, ok := m.delete(key); ok {
+			v.removed.Store(true)
+
+			if v.admitted {
+				r.release(key)
+			}
+		}
+	}
+}
+
+// resetCounterKey zeroes key in the counter maps.  Must hold ctxLock.
+func (r *Registry) resetCounterKey(key string) {
+	for _, m := range []*shardedMap[*counter]{r.countersByName, r.counters} {
+		if c, ok := m.get(key); ok {
+			c.zero()
+			c.oldData = 0
+			c.rollup = rollups{}
+			c.history = deltas{}
+			c.rates = ewma{}
+			c.exemplar.Store(nil)
+		}
+	}
+}
+
+// resetValueKey zeroes key in the value maps.  Must hold ctxLock.
+func (r *Registry) resetValueKey(key string) {
+	for _, m := range []*shardedMap[*value]{r.valuesByName, r.values} {
+		if v, ok := m.get(key); ok {
+			v.zero()
+			v.rollup = rollups{}
+			v.history = deltas{}
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"testing"
+)
+
+func TestRemoveAndReset(t *testing.T) {
+	r := NewRegistry()
+	r.SetSuffixMode("removable", PerCaller)
+
+	r.IncrDeltaSyncSuffix("removable", 3, "a")
+	r.IncrDeltaSyncLabels("removable", 2, L("k", "v"))
+	r.IncrDeltaSyncSuffix("resettable", 5, "a")
+	r.IncrDeltaSyncSuffix("removable_not", 1, "a")
+
+	r.Remove("removable")
+
+	for _, k := range []string{"removable", "removable/a", "removable{k=v}"} {
+		if _, ok := r.countersByName.get(k); ok {
+			t.Errorf("Expected %s removed", k)
+		}
+
+		if _, ok := r.counters.get(k); ok {
+			t.Errorf("Expected %s removed", k)
+		}
+	}
+
+	if got := r.ReadSync("removable_not"); got != 1 {
+		t.Errorf("Expected removable_not to stay, got %d", got)
+	}
+
+	r.Reset("resettable")
+
+	if got := r.ReadSync("resettable"); got != 0 {
+		t.Errorf("Expected resettable zeroed, got %d", got)
+	}
+
+	r.IncrSyncSuffix("resettable", "a")
+	r.ResetAll()
+
+	if got := r.ReadSync("resettable") + r.ReadSync("removable_not"); got != 0 {
+		t.Errorf("Expected all zeroed, got %d", got)
+	}
+}
+
+func TestIdleExpiry(t *testing.T) {
+	r := NewRegistry()
+	r.SetIdleExpiry(2)
+
+	r.IncrSyncSuffix("idle", "a")
+	r.IncrSyncSuffix("busy", "a")
+	r.getOrMakeAndSetValue("idle_val", "a", 1.0)
+
+	r.LogCounters() // idle and busy change from 0
+
+	r.IncrSyncSuffix("busy", "a")
+	r.LogCounters() // idle, idle_val 1 interval idle
+
+	r.IncrSyncSuffix("busy", "a")
+	r.LogCounters() // idle, idle_val 2 intervals idle
+
+	if _, ok := r.countersByName.get("idle"); ok {
+		t.Errorf("Expected idle counter to expire")
+	}
+
+	if _, ok := r.valuesByName.get("idle_val"); ok {
+		t.Errorf("Expected idle value to expire")
+	}
+
+	if got := r.ReadSync("busy"); got != 3 {
+		t.Errorf("Expected busy to stay, got %d", got)
+	}
+}
+
+// TestIdleCounterKeepsValue checks a counter expiring doesn't take a
+// value of the same name with it.
+func TestIdleCounterKeepsValue(t *testing.T) {
+	r := NewRegistry()
+	r.SetIdleExpiry(1)
+
+	r.IncrSyncSuffix("shared", "a")
+	r.getOrMakeAndSetValue("shared", "a", 1)
+	r.LogCounters() // both change from 0
+
+	r.getOrMakeAndSetValue("shared", "a", 2)
+	r.LogCounters() // the counter is idle, the value isn't
+
+	if _, ok := r.countersByName.get("shared"); ok {
+		t.Errorf("Expected the idle counter to expire")
+	}
+
+	if _, ok := r.valuesByName.get("shared"); !ok {
+		t.Errorf("Expected the value set this interval to stay")
+	}
+}
+
+// TestRemoveByBaseName checks Remove and SetValueMode only take name's
+// own breakdowns, not those of a name it is a prefix of.
+func TestRemoveByBaseName(t *testing.T) {
+	r := NewRegistry()
+	r.SetSuffixMode("api", PerCaller)
+	r.SetSuffixMode("api/v1", PerCaller)
+
+	r.IncrDeltaSyncSuffix("api", 1, "x")
+	r.IncrDeltaSyncSuffix("api/v1", 2, "x")
+	r.getOrMakeAndSetValue("api", "x", 1)
+	r.getOrMakeAndSetValue("api/v1", "x", 2)
+
+	r.SetValueMode("api", ValueSum)
+
+	if v, ok := r.values.get("api/v1/x"); !ok || v.mode != ValueLast {
+		t.Errorf("Expected api/v1/x's mode left alone")
+	}
+
+	r.Remove("api")
+
+	if _, ok := r.counters.get("api/x"); ok {
+		t.Errorf("Expected api/x removed")
+	}
+
+	if got := r.ReadSync("api/v1/x"); got != 2 {
+		t.Errorf("Expected api/v1/x to stay, got %d", got)
+	}
+
+	if _, ok := r.values.get("api/v1/x"); !ok {
+		t.Errorf("Expected the value api/v1/x to stay")
+	}
+}
+
+func TestRemoveDistribution(t *testing.T) {
+	r := NewRegistry()
+	r.MarkDistributionSyncSuffix("rmdist", 1113, "a")
+	r.MarkDistributionSyncSuffix("rmdist", 0, "a")
+	r.MarkDistributionSyncSuffix("rmdist_not", 5, "a")
+
+	r.Remove("rmdist")
+
+	for _, e := range sortedEntries(r.countersByName, r.counters) {
+		if b, ok := r.buckets.get(e.v.name); ok && b.dist == "rmdist" {
+			t.Errorf("Expected bucket %s removed", e.key)
+		}
+	}
+
+	if len(r.Snapshot().Distributions) != 1 {
+		t.Errorf("Expected rmdist_not to stay got %v", r.Snapshot().Distributions)
+	}
+}
+
+// TestHandlesOutliveRemoval checks handles' counters don't idle out
+// and a handle whose counter is removed makes it again.
+func TestHandlesOutliveRemoval(t *testing.T) {
+	r := NewRegistry()
+	r.SetIdleExpiry(1)
+
+	h := r.NewCounter("held")
+	v := r.NewValue("held_val")
+	d := r.NewDistribution("held_dist")
+
+	h.Add(1)
+	v.Set(1)
+	d.Mark(5)
+
+	for range 3 {
+		r.LogCounters()
+	}
+
+	h.Add(5)
+
+	if got := r.ReadSync("held"); got != 6 {
+		t.Errorf("Expected the held counter kept through idle expiry, got %d", got)
+	}
+
+	if _, ok := r.valuesByName.get("held_val"); !ok {
+		t.Errorf("Expected the held value kept through idle expiry")
+	}
+
+	r.Remove("held")
+	r.Remove("held_val")
+	r.Remove("held_dist")
+	h.Add(2)
+	v.Set(3)
+	d.Mark(5)
+
+	if got := r.ReadSync("held"); got != 2 || h.Read() != 2 {
+		t.Errorf("Expected the handle to make held again, got %d %d", got, h.Read())
+	}
+
+	if hv, ok := r.valuesByName.get("held_val"); !ok || hv.data != 3 {
+		t.Errorf("Expected the handle to make held_val again")
+	}
+
+	if got := r.ReadSync(r.deriveDistName("held_dist", 5)); got != 1 {
+		t.Errorf("Expected the handle to make the bucket again, got %d", got)
+	}
+}
+
+// TestIdleValueInUse checks a value set to the same result every
+// interval isn't idle.
+func TestIdleValueInUse(t *testing.T) {
+	r := NewRegistry()
+	r.SetIdleExpiry(2)
+	r.SetValueMode("steady", ValueCount)
+
+	for range 4 {
+		for range 10 {
+			r.getOrMakeAndSetValue("steady", "a", 1.0)
+		}
+
+		r.LogCounters()
+
+		if _, ok := r.valuesByName.get("steady"); !ok {
+			t.Fatalf("Expected steady to stay while set")
+		}
+	}
+
+	r.LogCounters()
+	r.LogCounters()
+
+	if _, ok := r.valuesByName.get("steady"); ok {
+		t.Errorf("Expected steady to expire once no longer set")
+	}
+}
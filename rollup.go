@@ -0,0 +1,246 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// this rollup.go file keeps a short history of each counter and
+// value so LogCounters and the reporters can show e.g. the last
+// minute, five minutes and hour side by side; see SetRollups.
+
+// SinceStart as a rollup window covers everything since
+// InitCounters (or the last Reset).
+const SinceStart time.Duration = 0
+
+// maxRollupSamples bounds the history kept per counter or value, so
+// windows much longer than the log interval cover less than asked.
+const maxRollupSamples = 3600
+
+// Rollup is the counter's change or the value aggregated per its
+// ValueMode over the last Window as of the report.
+type Rollup struct {
+	Window time.Duration
+	Value  float64
+}
+
+// rollupSample is one interval's counter delta or value.
+type rollupSample struct {
+	t time.Time
+	v float64
+}
+
+// rollups is the history for one counter or value.  Guarded by
+// ctxLock.
+type rollups struct {
+	samples []rollupSample // oldest first
+	n       float64        // since start
+	sum     float64
+	min     float64
+	max     float64
+}
+
+// SetRollups sets the windows shown as extra columns by LogCounters
+// and passed in MetricReport and ValReport, e.g.
+// SetRollups(time.Minute, 5*time.Minute, time.Hour, SinceStart).
+// Windows are best a multiple of the log interval.  No windows (the
+// default) turns them off.
+func (r *Registry) SetRollups(windows ...time.Duration) {
+	r.ctxLock.Lock()
+	r.rollupWindows = append([]time.Duration(nil), windows...)
+	r.ctxLock.Unlock()
+}
+
+// add records the interval ending at t, dropping what no window
+// needs any more.
+func (ru *rollups) add(t time.Time, v float64, keep time.Duration) {
+	if ru.n == 0 || v < ru.min {
+		ru.min = v
+	}
+
+	if ru.n == 0 || v > ru.max {
+		ru.max = v
+	}
+
+	ru.n++
+	ru.sum += v
+
+	ru.samples = append(ru.samples, rollupSample{t, v})
+
+	drop := max(len(ru.samples)-maxRollupSamples, 0)
+	for drop < len(ru.samples)-1 && !ru.samples[drop].t.After(t.Add(-keep)) {
+		drop++
+	}
+
+	if drop > 0 {
+		ru.samples = append(ru.samples[:0], ru.samples[drop:]...)
+	}
+}
+
+// window aggregates the samples in the window w up to now: summed
+// for counters (ValueSum) and per mode for values.  A sample must be
+// slack inside the window, half the log interval, so a LogCounters a
+// few ms late doesn't bring in one interval too many.
+func (ru *rollups) window(now time.Time, w time.Duration, slack time.Duration, mode ValueMode) float64 {
+	if w == SinceStart {
+		return aggregate(mode, ru.n, ru.sum, ru.min, ru.max, ru.last())
+	}
+
+	n, sum, lo, hi := 0.0, 0.0, 0.0, 0.0
+	cut := now.Add(slack - w)
+
+	for _, s := range ru.samples {
+		if !s.t.After(cut) {
+			continue
+		}
+
+		if n == 0 || s.v < lo {
+			lo = s.v
+		}
+
+		if n == 0 || s.v > hi {
+			hi = s.v
+		}
+
+		n++
+		sum += s.v
+	}
+
+	return aggregate(mode, n, sum, lo, hi, ru.last())
+}
+
+func (ru *rollups) last() float64 {
+	if len(ru.samples) == 0 {
+		return 0
+	}
+
+	return ru.samples[len(ru.samples)-1].v
+}
+
+// aggregate combines interval values per mode; a mean is the mean of
+// the intervals' values.
+func aggregate(mode ValueMode, n float64, sum float64, lo float64, hi float64, last float64) float64 {
+	switch mode {
+	case ValueSum, ValueCount:
+		return sum
+	case ValueMin:
+		return lo
+	case ValueMax:
+		return hi
+	case ValueMean:
+		if n > 0 {
+			return sum / n
+		}
+
+		return 0
+	case ValueLast:
+	}
+
+	return last
+}
+
+// rollupFor records v for the interval ending at now and returns the
+// configured windows.  Must hold ctxLock.
+func (r *Registry) rollupFor(ru *rollups, now time.Time, v float64, mode ValueMode) []Rollup {
+	if len(r.rollupWindows) == 0 {
+		return nil
+	}
+
+	ru.add(now, v, slices.Max(r.rollupWindows))
+
+	res := make([]Rollup, len(r.rollupWindows))
+	slack := r.logInterval() / 2 //nolint:mnd
+
+	for i, w := range r.rollupWindows {
+		res[i] = Rollup{Window: w, Value: ru.window(now, w, slack, mode)}
+	}
+
+	return res
+}
+
+// rollupColumns formats the rollups as extra log columns.
+func rollupColumns(rus []Rollup, asInt bool) string {
+	var sb strings.Builder
+
+	for _, ru := range rus {
+		if asInt {
+			fmt.Fprintf(&sb, " %20d", int64(ru.Value))
+		} else {
+			fmt.Fprintf(&sb, " %20f", ru.Value)
+		}
+	}
+
+	return sb.String()
+}
+
+// rollupHeader is the column names for the configured windows.  Must
+// hold ctxLock.
+func (r *Registry) rollupHeader() string {
+	var sb strings.Builder
+
+	for _, w := range r.rollupWindows {
+		fmt.Fprintf(&sb, " %20s", windowName(w))
+	}
+
+	return sb.String()
+}
+
+// windowName is e.g. 5m rather than 5m0s.
+func windowName(w time.Duration) string {
+	if w == SinceStart {
+		return "start"
+	}
+
+	s := w.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+
+	return s
+}
+
+// maxHistory is how many interval deltas are kept per counter or
+// value for SnapshotHistory.
+const maxHistory = 120
+
+// deltas is a ring of the last maxHistory interval deltas.  Guarded
+// by ctxLock.
+type deltas struct {
+	ring []float64
+	next int // oldest once the ring is full
+}
+
+func (d *deltas) add(v float64) {
+	if len(d.ring) < maxHistory {
+		d.ring = append(d.ring, v)
+
+		return
+	}
+
+	d.ring[d.next] = v
+	d.next = (d.next + 1) % maxHistory
+}
+
+// lastN is up to the last n deltas, oldest first.
+func (d *deltas) lastN(n int) []float64 {
+	n = min(n, len(d.ring))
+	if n <= 0 {
+		return nil
+	}
+
+	res := make([]float64, 0, n)
+
+	for i := len(d.ring) - n; i < len(d.ring); i++ {
+		res = append(res, d.ring[(d.next+i)%len(d.ring)])
+	}
+
+	return res
+}
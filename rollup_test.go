@@ -0,0 +1,106 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRollupWindows(t *testing.T) {
+	ru := rollups{}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+
+	for i := range 10 {
+		ru.add(start.Add(time.Duration(i+1)*time.Minute), float64(i+1), 5*time.Minute)
+	}
+
+	now := start.Add(10 * time.Minute)
+
+	for _, c := range []struct {
+		w    time.Duration
+		mode ValueMode
+		want float64
+	}{
+		{time.Minute, ValueSum, 10},
+		{5 * time.Minute, ValueSum, 6 + 7 + 8 + 9 + 10},
+		{5 * time.Minute, ValueMin, 6},
+		{5 * time.Minute, ValueMax, 10},
+		{5 * time.Minute, ValueMean, 8},
+		{5 * time.Minute, ValueLast, 10},
+		{SinceStart, ValueSum, 55},
+		{SinceStart, ValueMin, 1},
+	} {
+		if got := ru.window(now, c.w, time.Minute/2, c.mode); got != c.want {
+			t.Errorf("Expected %v for %v %v got %v", c.want, windowName(c.w), c.mode, got)
+		}
+	}
+
+	if len(ru.samples) != 5 {
+		t.Errorf("Expected history trimmed to 5 got %d", len(ru.samples))
+	}
+}
+
+// TestRollupJitter checks LogCounters running a little off the tick
+// doesn't put an extra interval in a window.
+func TestRollupJitter(t *testing.T) {
+	ru := rollups{}
+	start := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	jitter := []time.Duration{0, 5 * time.Millisecond, -3 * time.Millisecond, 40 * time.Millisecond, 5 * time.Millisecond}
+
+	for i, j := range jitter {
+		ru.add(start.Add(time.Duration(i)*time.Minute+j), 100, time.Hour)
+	}
+
+	now := start.Add(4*time.Minute + 5*time.Millisecond)
+
+	for w, want := range map[time.Duration]float64{time.Minute: 100, 2 * time.Minute: 200, 5 * time.Minute: 500} {
+		if got := ru.window(now, w, time.Minute/2, ValueSum); got != want {
+			t.Errorf("Expected %v for %v got %v", want, windowName(w), got)
+		}
+	}
+}
+
+func TestRollupReports(t *testing.T) {
+	r := NewRegistry()
+	r.SetRollups(time.Hour, SinceStart)
+
+	var got []MetricReport
+
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		for _, m := range metrics {
+			if m.Name == "rolled" {
+				got = append(got, m)
+			}
+		}
+	})
+
+	for range 3 {
+		r.IncrDeltaSyncSuffix("rolled", 2, "a")
+		logAndFlush(t, r)
+	}
+
+	if len(got) != 3 {
+		t.Fatalf("Expected 3 reports got %v", got)
+	}
+
+	rus := got[2].Rollups
+	if len(rus) != 2 || rus[0].Value != 6 || rus[1].Value != 6 {
+		t.Errorf("Expected 6 for the hour and since start got %v", rus)
+	}
+}
+
+func TestWindowName(t *testing.T) {
+	for w, want := range map[time.Duration]string{
+		time.Minute:            "1m",
+		5 * time.Minute:        "5m",
+		time.Hour:              "1h",
+		90 * time.Second:       "1m30s",
+		500 * time.Millisecond: "500ms",
+		SinceStart:             "start",
+	} {
+		if got := windowName(w); got != want {
+			t.Errorf("Expected %s got %s", want, got)
+		}
+	}
+}
@@ -8,8 +8,8 @@ import (
 )
 
 func getCallerFunctionName() string {
-	// Skip GetCallerFunctionName and the function to get the caller of
-	c := getFrame(3).Function // nolint:mnd
+	// Skip getCallerFunctionName and the API function to get the caller of
+	c := getFrame(2).Function // nolint:mnd
 	if strings.Contains(c, "/") {
 		cs := strings.Split(c, "/")
 		c = cs[len(cs)-1]
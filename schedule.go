@@ -0,0 +1,66 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"time"
+)
+
+// this schedule.go file has the go routine that logs the counters
+// every interval, lined up with the wall clock so e.g. a one minute
+// interval logs on the minute.
+
+// defaultInterval is the log interval if SetLogInterval isn't called.
+const defaultInterval = time.Minute
+
+// logInterval returns the current interval, see SetLogInterval.
+func (r *Registry) logInterval() time.Duration {
+	d := time.Duration(r.interval.Load())
+	if d <= 0 {
+		return defaultInterval
+	}
+
+	return d
+}
+
+// nextTick returns the first multiple of d (since the zero time, so
+// UTC) after now.
+func nextTick(now time.Time, d time.Duration) time.Time {
+	return now.Truncate(d).Add(d)
+}
+
+func (r *Registry) minuteGoRoutine(finished chan struct{}, changed chan struct{}) {
+	defer r.wg.Done()
+
+	next := nextTick(time.Now(), r.logInterval())
+	timer := time.NewTimer(time.Until(next))
+
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-finished:
+			return
+		case <-changed:
+			next = nextTick(time.Now(), r.logInterval())
+		case <-timer.C:
+			r.checkRuntime()
+			r.LogCounters()
+
+			// the timer can fire a touch early by the wall clock, so
+			// never pick the same tick twice
+			next = nextTick(later(time.Now(), next), r.logInterval())
+		}
+
+		timer.Reset(time.Until(next))
+	}
+}
+
+// later returns whichever of a and b is later.
+func later(a time.Time, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+
+	return b
+}
@@ -0,0 +1,58 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextTick(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 17, 42, 5, time.UTC)
+
+	for _, c := range []struct {
+		d    time.Duration
+		want time.Time
+	}{
+		{time.Minute, time.Date(2024, 5, 1, 10, 18, 0, 0, time.UTC)},
+		{5 * time.Minute, time.Date(2024, 5, 1, 10, 20, 0, 0, time.UTC)},
+		{250 * time.Millisecond, time.Date(2024, 5, 1, 10, 17, 42, int(250*time.Millisecond), time.UTC)},
+	} {
+		if got := nextTick(now, c.d); !got.Equal(c.want) {
+			t.Errorf("Expected %v for %v got %v", c.want, c.d, got)
+		}
+	}
+}
+
+func TestSetLogIntervalAtRuntime(t *testing.T) {
+	r := NewRegistry()
+
+	reports := make(chan MetricReport, 100)
+
+	r.SetMetricReporter(func(metrics []MetricReport) {
+		for _, m := range metrics {
+			if m.Name == "sched_ticks" {
+				reports <- m
+			}
+		}
+	})
+
+	r.IncrSyncSuffix("sched_ticks", "a")
+	// the registry started with the default minute so this only
+	// reports if the change is picked up
+	r.SetLogInterval(0.1)
+
+	select {
+	case m := <-reports:
+		if m.Interval <= 0 || m.Interval > 200*time.Millisecond {
+			t.Errorf("Expected an interval of at most 100ms got %v", m.Interval)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Expected a report after SetLogInterval")
+	}
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Errorf("Shutdown: %v", err)
+	}
+}
@@ -0,0 +1,182 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"time"
+)
+
+// this snapshot.go file has Snapshot, a point in time copy of every
+// metric that can be looked at without scraping the logs.
+
+// RegistrySnapshot is a consistent copy of a Registry at Time.  Deltas are
+// since the last LogCounters, as in the log and reports.
+type RegistrySnapshot struct {
+	Time          time.Time
+	Start         time.Time // when InitCounters was called
+	Counters      []CounterSnapshot
+	Values        []ValueSnapshot
+	Meta          []MetaSnapshot
+	Distributions []DistributionSnapshot
+}
+
+// CounterSnapshot is one counter in a RegistrySnapshot.  Name is as in
+// LogCounters, i.e. with any suffix or labels, and Base without.
+type CounterSnapshot struct {
+	Name        string
+	Base        string
+	Suffix      string
+	Labels      []Label
+	Total       int64
+	Delta       int64
+	FirstSeen   time.Time
+	LastUpdated time.Time
+	History     []float64 // see SnapshotHistory
+}
+
+// ValueSnapshot is one value in a RegistrySnapshot.  Value is the current
+// interval's value so far aggregated per Mode and Last the one last
+// reported.
+type ValueSnapshot struct {
+	Name        string
+	Base        string
+	Suffix      string
+	Labels      []Label
+	Value       float64
+	Last        float64
+	Delta       float64
+	Mode        ValueMode
+	FirstSeen   time.Time
+	LastUpdated time.Time
+	History     []float64 // see SnapshotHistory
+}
+
+// MetaSnapshot is one meta counter (or one suffix of a PerCaller
+// one) in a RegistrySnapshot.
+type MetaSnapshot struct {
+	Name  string
+	Total float64
+	Delta float64
+}
+
+// DistributionSnapshot is a distribution made by MarkDistribution
+// and friends with its buckets sorted by Lower.  There is one per
+// suffix or label set as well as the merged one.
+type DistributionSnapshot struct {
+	Name    string
+	Suffix  string
+	Labels  []Label
+	Buckets []BucketSnapshot
+}
+
+// BucketSnapshot is one bucket of a distribution.  Name is the
+// bucket's counter as in LogCounters, Bucket just the range part.
+// Lower and Upper are both 0 for the zero bucket and for buckets
+// whose range can't be worked out (Known false), e.g. odd sizes.
+type BucketSnapshot struct {
+	Name        string
+	Bucket      string
+	Lower       float64
+	Upper       float64
+	Known       bool
+	Total       int64
+	Delta       int64
+	FirstSeen   time.Time
+	LastUpdated time.Time
+	Exemplar    *Exemplar // the latest, see MarkDistributionExemplar
+	History     []float64 // see SnapshotHistory
+}
+
+// Snapshot returns a copy of all the counters, values, meta counters
+// and distribution buckets.  It doesn't change what LogCounters
+// reports.
+func (r *Registry) Snapshot() RegistrySnapshot {
+	return r.SnapshotHistory(0)
+}
+
+// SnapshotHistory is Snapshot with the last n (at most 120) interval
+// deltas, oldest first, in each History.
+func (r *Registry) SnapshotHistory(n int) RegistrySnapshot {
+	ctrs := sortedEntries(r.countersByName, r.counters)
+	vals := sortedEntries(r.valuesByName, r.values)
+	now := time.Now()
+
+	r.ctxLock.Lock()
+	defer r.ctxLock.Unlock()
+
+	s := RegistrySnapshot{Time: now, Start: r.startTime}
+
+	for _, name := range r.metaNames() {
+		s.Meta = append(s.Meta, r.metaLines(r.metaCtrs[name])...)
+	}
+
+	for _, e := range vals {
+		v := e.v
+
+		v.mu.Lock()
+		s.Values = append(s.Values, ValueSnapshot{
+			Name:        e.key,
+			Base:        v.name,
+			Suffix:      v.suffix,
+			Labels:      v.labels,
+			Value:       v.current(),
+			Last:        v.oldData,
+			Delta:       v.current() - v.oldData,
+			Mode:        v.mode,
+			FirstSeen:   v.firstSeen,
+			LastUpdated: v.lastUpdated,
+			History:     v.history.lastN(n),
+		})
+		v.mu.Unlock()
+	}
+
+	dists := bucketGroups[BucketSnapshot]{}
+
+	for _, e := range ctrs {
+		c := e.v
+		total := c.load()
+		c.noteChange(total, now)
+
+		info, ok := r.buckets.get(c.name)
+		if !ok {
+			s.Counters = append(s.Counters, CounterSnapshot{
+				Name:        e.key,
+				Base:        c.name,
+				Suffix:      c.suffix,
+				Labels:      c.labels,
+				Total:       total,
+				Delta:       total - c.oldData,
+				FirstSeen:   c.firstSeen,
+				LastUpdated: c.lastUpdated,
+				History:     c.history.lastN(n),
+			})
+
+			continue
+		}
+
+		dists.add(info, c, BucketSnapshot{
+			Name:        e.key,
+			Bucket:      info.bucket,
+			Lower:       info.lower,
+			Upper:       info.upper,
+			Known:       info.ok,
+			Total:       total,
+			Delta:       total - c.oldData,
+			FirstSeen:   c.firstSeen,
+			LastUpdated: c.lastUpdated,
+			Exemplar:    c.exemplar.Load(),
+			History:     c.history.lastN(n),
+		})
+	}
+
+	for _, bg := range dists.sorted(func(b BucketSnapshot) float64 { return b.Lower }) {
+		s.Distributions = append(s.Distributions, DistributionSnapshot{
+			Name:    bg.dist,
+			Suffix:  bg.suffix,
+			Labels:  bg.labels,
+			Buckets: bg.buckets,
+		})
+	}
+
+	return s
+}
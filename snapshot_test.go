@@ -0,0 +1,105 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+
+	r.IncrDeltaSyncSuffix("snap_good", 9, "a")
+	r.IncrDeltaSyncSuffix("snap_bad", 1, "a")
+	r.AddMetaCounter("snap_avail", "snap_good", "snap_bad", RatioTotal)
+	r.NewValue("snap_val").Set(2.5)
+
+	d := r.NewDistribution("snap_dist")
+	d.Mark(1113.0)
+	d.Mark(1.0)
+	d.Mark(0.0)
+	d.Mark(-1113.0)
+
+	r.LogCounters()
+	r.IncrDeltaSyncSuffix("snap_good", 1, "a")
+
+	s := r.Snapshot()
+
+	var good *CounterSnapshot
+
+	for i, c := range s.Counters {
+		if c.Name == "snap_good" {
+			good = &s.Counters[i]
+		}
+
+		if c.Base == "snap_dist" || c.Name == "snap_dist [zero]" {
+			t.Errorf("Expected bucket %s only in Distributions", c.Name)
+		}
+	}
+
+	if good == nil {
+		t.Fatalf("Expected snap_good in %v", s.Counters)
+	}
+
+	if good.Total != 10 || good.Delta != 1 {
+		t.Errorf("Expected total 10 delta 1 got %d %d", good.Total, good.Delta)
+	}
+
+	if good.FirstSeen.IsZero() || good.LastUpdated.Before(good.FirstSeen) {
+		t.Errorf("Bad times %v %v", good.FirstSeen, good.LastUpdated)
+	}
+
+	if len(s.Values) != 1 || s.Values[0].Value != 2.5 || s.Values[0].Delta != 0 {
+		t.Errorf("Expected snap_val 2.5 got %v", s.Values)
+	}
+
+	if len(s.Meta) != 1 || s.Meta[0].Name != "snap_avail" || s.Meta[0].Total != 10.0/11.0 {
+		t.Errorf("Expected snap_avail 10/11 got %v", s.Meta)
+	}
+
+	if len(s.Distributions) != 1 {
+		t.Fatalf("Expected 1 distribution got %v", s.Distributions)
+	}
+
+	want := []struct {
+		bucket string
+		lower  float64
+		upper  float64
+	}{
+		{"001.1k-1.2k", -1200, -1100},
+		{"zero", 0, 0},
+		{"001.0-1.1", 1, 1.1},
+		{"001.1k-1.2k", 1100, 1200},
+	}
+
+	b := s.Distributions[0].Buckets
+	if len(b) != len(want) {
+		t.Fatalf("Expected %d buckets got %v", len(want), b)
+	}
+
+	for i, w := range want {
+		if b[i].Bucket != w.bucket || b[i].Total != 1 ||
+			!near(b[i].Lower, w.lower) || !near(b[i].Upper, w.upper) {
+			t.Errorf("Expected bucket %v got %v", w, b[i])
+		}
+	}
+}
+
+func TestParseBucket(t *testing.T) {
+	theCtx.SetResolution(LowRes)
+	defer theCtx.SetResolution(HighRes)
+
+	for _, v := range []float64{0.004, 7, 2113, 5e7} {
+		b := parseBucket("pb", theCtx.deriveDistName("pb", v))
+		if !b.ok || b.lower > v || b.upper < v {
+			t.Errorf("Expected %f in bucket got %v", v, b)
+		}
+	}
+}
+
+func near(a float64, b float64) bool {
+	d := a - b
+
+	return d < 1e-9 && d > -1e-9
+}
@@ -0,0 +1,275 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// this statsd.go file sends the counters to a StatsD (or DogStatsD)
+// agent over UDP: counter deltas as |c and values as |g each interval
+// and every distribution sample as it is marked as |ms or |h.
+
+// StatsDOptions configure a StatsD; zero fields get the defaults.
+type StatsDOptions struct {
+	Prefix        string        // put before every name, e.g. "myapp."
+	MTU           int           // largest packet, default 1432
+	DogStatsD     bool          // suffix and labels as #tags rather than in the name
+	Histogram     bool          // samples as |h rather than |ms
+	SampleRate    float64       // fraction of samples sent, default 1
+	FlushInterval time.Duration // longest a sample waits for a full packet, default 1s
+}
+
+// StatsD is a Reporter and SampleObserver sending to a StatsD agent.
+type StatsD struct {
+	r    *Registry
+	opts StatsDOptions
+	conn net.Conn
+	mu   sync.Mutex
+	buf  []byte // the packet being filled
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewStatsD connects to the StatsD agent at addr (host:port) and adds
+// it as a Reporter and SampleObserver; Close removes it again.
+func (r *Registry) NewStatsD(addr string, opts StatsDOptions) (*StatsD, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.MTU <= 0 {
+		opts.MTU = 1432 //nolint:mnd
+	}
+
+	if opts.SampleRate <= 0 || opts.SampleRate > 1 {
+		opts.SampleRate = 1
+	}
+
+	if opts.FlushInterval <= 0 {
+		opts.FlushInterval = time.Second
+	}
+
+	s := &StatsD{
+		r:    r,
+		opts: opts,
+		conn: conn,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	go s.flusher()
+
+	r.AddReporter(s)
+	r.AddSampleObserver(s)
+
+	return s, nil
+}
+
+// Close removes the StatsD from the registry, sends what is buffered
+// and closes the connection.
+func (s *StatsD) Close() error {
+	s.r.RemoveSampleObserver(s)
+	s.r.removeReporterWait(s) // no Report can be writing after this
+
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.flushLocked()
+	if cerr := s.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Report sends the counter deltas, values and meta counters.
+func (s *StatsD) Report(_ context.Context, rep *Report) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var firstErr error
+
+	send := func(line string) {
+		if err := s.addLocked(line); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	ctrs := rep.Counters
+	if s.opts.DogStatsD { // with tags the merged total would count twice
+		ctrs = rep.exported().Counters
+	}
+
+	for _, c := range ctrs {
+		if c.Delta != 0 {
+			send(s.line(c.Base, c.Name, c.Suffix, c.Labels, strconv.FormatInt(c.Delta, 10), "c", 1))
+		}
+	}
+
+	for _, v := range rep.Values {
+		val := formatFloat(v.Value)
+		if v.Value < 0 { // a signed gauge is a change, so zero it first
+			send(s.line(v.Base, v.Name, v.Suffix, v.Labels, "0", "g", 1))
+		}
+
+		send(s.line(v.Base, v.Name, v.Suffix, v.Labels, val, "g", 1))
+	}
+
+	for _, m := range rep.Meta {
+		base, suffix, _ := strings.Cut(m.Name, "/")
+		send(s.line(base, m.Name, suffix, nil, formatFloat(m.Total), "g", 1))
+	}
+
+	if err := s.flushLocked(); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+// ObserveSample sends (some of) the distribution samples.
+func (s *StatsD) ObserveSample(name string, value float64, suffix string, labels []Label) {
+	if s.opts.SampleRate < 1 && rand.Float64() >= s.opts.SampleRate { //nolint:gosec
+		return
+	}
+
+	typ := "ms"
+	if s.opts.Histogram {
+		typ = "h"
+	}
+
+	full := name
+	if len(labels) > 0 {
+		full = labelKey(name, sortedLabels(labels))
+	}
+
+	if suffix != "" {
+		full += "/" + suffix
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_ = s.addLocked(s.line(name, full, suffix, labels, formatFloat(value), typ, s.opts.SampleRate))
+}
+
+// line makes one name:value|type[|@rate][|#tags] line.  Without
+// DogStatsD the full name (with suffix) is the metric name.
+func (s *StatsD) line(base string, full string, suffix string, labels []Label, val string, typ string, rate float64) string {
+	var sb strings.Builder
+
+	if s.opts.DogStatsD {
+		sb.WriteString(statsdName(s.opts.Prefix + base))
+	} else {
+		sb.WriteString(statsdName(s.opts.Prefix + full))
+	}
+
+	sb.WriteString(":" + val + "|" + typ)
+
+	if rate < 1 {
+		sb.WriteString("|@" + strconv.FormatFloat(rate, 'g', -1, 64))
+	}
+
+	if s.opts.DogStatsD && (suffix != "" || len(labels) > 0) {
+		tags := make([]string, 0, len(labels)+1)
+
+		if suffix != "" {
+			tags = append(tags, "suffix:"+statsdTag(suffix))
+		}
+
+		for _, l := range labels {
+			tags = append(tags, statsdTag(l.Key)+":"+statsdTag(l.Value))
+		}
+
+		sb.WriteString("|#" + strings.Join(tags, ","))
+	}
+
+	return sb.String()
+}
+
+// addLocked adds line to the packet, sending it first if line won't
+// fit.  Must hold s.mu.
+func (s *StatsD) addLocked(line string) error {
+	var err error
+
+	if len(s.buf) > 0 && len(s.buf)+1+len(line) > s.opts.MTU {
+		err = s.flushLocked()
+	}
+
+	if len(s.buf) > 0 {
+		s.buf = append(s.buf, '\n')
+	}
+
+	s.buf = append(s.buf, line...)
+
+	return err
+}
+
+// flushLocked sends the packet.  Must hold s.mu.
+func (s *StatsD) flushLocked() error {
+	if len(s.buf) == 0 {
+		return nil
+	}
+
+	_, err := s.conn.Write(s.buf)
+	s.buf = s.buf[:0]
+
+	return err
+}
+
+// flusher sends samples sitting in a part full packet.
+func (s *StatsD) flusher() {
+	defer close(s.done)
+
+	t := time.NewTicker(s.opts.FlushInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-t.C:
+			s.mu.Lock()
+			_ = s.flushLocked()
+			s.mu.Unlock()
+		}
+	}
+}
+
+// statsdName makes the /suffix a .suffix and anything the protocol
+// uses (:|@#, space) or from labels ({=}) an underscore.
+func statsdName(name string) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case '/':
+			return '.'
+		case ':', '|', '@', '#', ',', ' ', '\n', '{', '}', '=':
+			return '_'
+		}
+
+		return c
+	}, name)
+}
+
+// statsdTag makes a tag key or value safe.
+func statsdTag(tag string) string {
+	return strings.Map(func(c rune) rune {
+		switch c {
+		case '|', '@', '#', ',', ' ', '\n', ':':
+			return '_'
+		}
+
+		return c
+	}, tag)
+}
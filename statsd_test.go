@@ -0,0 +1,167 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readPackets reads from pc until nothing arrives for a while.
+func readPackets(t *testing.T, pc net.PacketConn) []string {
+	t.Helper()
+
+	packets := []string{}
+	buf := make([]byte, 65536)
+
+	for {
+		_ = pc.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			return packets
+		}
+
+		packets = append(packets, string(buf[:n]))
+	}
+}
+
+func TestStatsD(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no UDP: %v", err)
+	}
+	defer pc.Close()
+
+	r := NewRegistry()
+	r.SetSuffixMode("hits", PerCaller)
+
+	s, err := r.NewStatsD(pc.LocalAddr().String(), StatsDOptions{
+		Prefix:    "app.",
+		MTU:       64,
+		DogStatsD: true,
+	})
+	if err != nil {
+		t.Fatalf("NewStatsD: %v", err)
+	}
+
+	r.IncrDeltaSyncSuffix("hits", 3, "main")
+	r.IncrDeltaSyncSuffix("hits", 4, "") // no tag, only in the merged total
+	r.IncrDeltaSyncSuffix("plain", 2, "a")
+	r.NewValue("temp").Set(-4.5)
+
+	d := r.NewDistribution("lat")
+	for range 10 {
+		d.Mark(12.5)
+	}
+
+	r.LogCounters()
+
+	if err := r.FlushReporters(context.Background()); err != nil {
+		t.Fatalf("FlushReporters: %v", err)
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	packets := readPackets(t, pc)
+	lines := []string{}
+
+	for _, p := range packets {
+		if len(p) > 64 {
+			t.Errorf("Packet over the MTU: %d %q", len(p), p)
+		}
+
+		lines = append(lines, strings.Split(p, "\n")...)
+	}
+
+	// a negative gauge has to be zeroed first
+	if raw := strings.Join(lines, "\n"); strings.Index(raw, "app.temp:0|g") > strings.Index(raw, "app.temp:-4.5|g") {
+		t.Errorf("Expected the gauge zeroed before going negative\n%s", raw)
+	}
+
+	sort.Strings(lines)
+	got := strings.Join(lines, "\n")
+
+	for _, want := range []string{
+		"app.hits:3|c|#suffix:main",
+		"app.hits:4|c\n",
+		"app.plain:2|c",
+		"app.temp:0|g",
+		"app.temp:-4.5|g",
+		"app.lat:12.5|ms",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Expected %q in\n%s", want, got)
+		}
+	}
+
+	if strings.Contains(got, "app.hits:7|c") {
+		t.Errorf("Expected only the merged total's untagged rest with tags\n%s", got)
+	}
+
+	if n := strings.Count(got, "app.lat:12.5|ms"); n != 10 {
+		t.Errorf("Expected 10 samples got %d", n)
+	}
+}
+
+func TestStatsDLine(t *testing.T) {
+	s := &StatsD{opts: StatsDOptions{}}
+
+	if got := s.line("a b", "a b/x:y", "x:y", nil, "1", "c", 0.5); got != "a_b.x_y:1|c|@0.5" {
+		t.Errorf("Got %s", got)
+	}
+
+	s.opts.DogStatsD = true
+
+	if got := s.line("req", "req{code=500}", "", []Label{L("code", "500")}, "2", "h", 1); got != "req:2|h|#code:500" {
+		t.Errorf("Got %s", got)
+	}
+}
+
+// TestStatsDCloseWaits checks Close lets a queued report finish
+// before closing the connection.
+func TestStatsDCloseWaits(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no UDP: %v", err)
+	}
+	defer pc.Close()
+
+	r := NewRegistry()
+
+	s, err := r.NewStatsD(pc.LocalAddr().String(), StatsDOptions{})
+	if err != nil {
+		t.Fatalf("NewStatsD: %v", err)
+	}
+
+	s.mu.Lock() // hold up the reports so they are in flight at Close
+
+	for i := range 5 {
+		r.IncrDeltaSyncSuffix("closing"+strconv.Itoa(i), 3, "a")
+		r.LogCounters()
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		s.mu.Unlock()
+	}()
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	got := strings.Join(readPackets(t, pc), "\n")
+
+	for i := range 5 {
+		if want := "closing" + strconv.Itoa(i) + ":3|c"; !strings.Contains(got, want) {
+			t.Errorf("Expected the queued %q sent before Close got\n%s", want, got)
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this storage.go file has the sharded maps and striped counter cells
+// that keep the hot path off any shared lock or shared cache line.
+// Each shard is a sync.Map, so lookups of existing names don't lock
+// and making a new name costs about the same however many there
+// are.  A counter starts with one cell and only when adders collide
+// on it (or a handle is made for it) spreads its increments over
+// cache line sized cells which are summed when read.
+
+import (
+	"maps"
+	"math/bits"
+	"math/rand/v2"
+	"runtime"
+	"sort"
+	"sync"
+	"sync/atomic"
+)
+
+// numShards is the number of shards per map, a power of 2.
+const numShards = 32
+
+// cacheLine is the size cells are padded to.
+const cacheLine = 64
+
+// numStripes is the number of cells a striped counter has:
+// GOMAXPROCS at start up rounded up to a power of 2 and capped at 64.
+var numStripes = stripesFor(runtime.GOMAXPROCS(0))
+
+func stripesFor(procs int) int {
+	n := 1 << bits.Len(uint(procs-1)) //nolint:gosec
+
+	return min(max(n, 1), 64) //nolint:mnd
+}
+
+// cell is one stripe of a counter on its own cache line.
+type cell struct {
+	n int64
+	_ [cacheLine - 8]byte
+}
+
+// add adds i to the counter's one cell or, once it is striped, a
+// random stripe; the runtime's random source is per thread so
+// concurrent adders mostly land on different stripes.
+func (c *counter) add(i int64) {
+	if s := c.stripes.Load(); s != nil {
+		atomic.AddInt64(&(*s)[rand.Uint32()&uint32(len(*s)-1)].n, i) //nolint:gosec
+
+		return
+	}
+
+	old := atomic.LoadInt64(&c.base.n)
+	if atomic.CompareAndSwapInt64(&c.base.n, old, old+i) {
+		return
+	}
+
+	// another adder got in between, so it's contended: spread out
+	if c.stripe() {
+		c.add(i)
+
+		return
+	}
+
+	atomic.AddInt64(&c.base.n, i)
+}
+
+// stripe gives the counter numStripes cells if it hasn't got them
+// already; what is in the one cell stays there.  False if there is
+// only one P so no point.
+func (c *counter) stripe() bool {
+	if numStripes == 1 {
+		return false
+	}
+
+	if c.stripes.Load() == nil {
+		s := make([]cell, numStripes)
+		c.stripes.CompareAndSwap(nil, &s)
+	}
+
+	return true
+}
+
+// zero sets all the cells to 0; adds racing with it may be lost.
+func (c *counter) zero() {
+	atomic.StoreInt64(&c.base.n, 0)
+
+	if s := c.stripes.Load(); s != nil {
+		for i := range *s {
+			atomic.StoreInt64(&(*s)[i].n, 0)
+		}
+	}
+}
+
+// load sums the cells.  It is not a snapshot of the cells together
+// but every add before the call is included.
+func (c *counter) load() int64 {
+	total := atomic.LoadInt64(&c.base.n)
+
+	if s := c.stripes.Load(); s != nil {
+		for i := range *s {
+			total += atomic.LoadInt64(&(*s)[i].n)
+		}
+	}
+
+	return total
+}
+
+// mapShard is one shard of a shardedMap; mu only serialises making
+// and deleting keys, lookups don't take it.
+type mapShard[V any] struct {
+	mu sync.Mutex
+	m  sync.Map // string to V
+	n  atomic.Int64
+}
+
+// shardedMap is a string keyed map split into numShards shards.
+type shardedMap[V any] struct {
+	shards [numShards]mapShard[V]
+}
+
+// mapEntry is a key and value copied out of a shardedMap.
+type mapEntry[V any] struct {
+	key string
+	v   V
+}
+
+func newShardedMap[V any]() *shardedMap[V] {
+	return &shardedMap[V]{}
+}
+
+// shard picks the shard for key with FNV-1a.
+func (s *shardedMap[V]) shard(key string) *mapShard[V] {
+	h := uint32(2166136261) //nolint:mnd
+
+	for i := range len(key) {
+		h ^= uint32(key[i])
+		h *= 16777619 //nolint:mnd
+	}
+
+	return &s.shards[h&(numShards-1)]
+}
+
+func (s *shardedMap[V]) get(key string) (V, bool) {
+	var v V
+
+	if s == nil {
+		return v, false
+	}
+
+	x, ok := s.shard(key).m.Load(key)
+	if !ok {
+		return v, false
+	}
+
+	return x.(V), true //nolint:forcetypeassert
+}
+
+// getOrMake returns the value at key, storing mk() there first if
+// there is none.
+func (s *shardedMap[V]) getOrMake(key string, mk func() V) V {
+	v, _ := s.getOrTryMake(key, func() (V, bool) { return mk(), true })
+
+	return v
+}
+
+// getOrTryMake returns the value at key, storing mk() there first if
+// there is none; if mk says no nothing is stored and it returns false.
+// mk is called with the shard locked.
+func (s *shardedMap[V]) getOrTryMake(key string, mk func() (V, bool)) (V, bool) {
+	if v, ok := s.get(key); ok {
+		return v, true
+	}
+
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	// another routine might have beat us to it, check again
+	if x, ok := sh.m.Load(key); ok {
+		return x.(V), true //nolint:forcetypeassert
+	}
+
+	v, ok := mk()
+	if !ok {
+		return v, false
+	}
+
+	sh.m.Store(key, v)
+	sh.n.Add(1)
+
+	return v, true
+}
+
+// delete removes key, returning what was there.
+func (s *shardedMap[V]) delete(key string) (V, bool) {
+	sh := s.shard(key)
+
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	var v V
+
+	x, ok := sh.m.LoadAndDelete(key)
+	if !ok {
+		return v, false
+	}
+
+	sh.n.Add(-1)
+
+	return x.(V), true //nolint:forcetypeassert
+}
+
+// reset empties the map in place so readers never see the map
+// itself change.
+func (s *shardedMap[V]) reset() {
+	for i := range s.shards {
+		sh := &s.shards[i]
+
+		sh.mu.Lock()
+		sh.m.Clear()
+		sh.n.Store(0)
+		sh.mu.Unlock()
+	}
+}
+
+// entries returns everything in the map in no particular order.
+func (s *shardedMap[V]) entries() []mapEntry[V] {
+	if s == nil {
+		return nil
+	}
+
+	res := make([]mapEntry[V], 0, s.len())
+
+	for i := range s.shards {
+		s.shards[i].m.Range(func(k any, v any) bool {
+			res = append(res, mapEntry[V]{k.(string), v.(V)}) //nolint:forcetypeassert
+
+			return true
+		})
+	}
+
+	return res
+}
+
+func (s *shardedMap[V]) len() int {
+	if s == nil {
+		return 0
+	}
+
+	n := int64(0)
+
+	for i := range s.shards {
+		n += s.shards[i].n.Load()
+	}
+
+	return int(n)
+}
+
+// sortedEntries merges the entries of a few maps sorted by key.
+func sortedEntries[V any](maps ...*shardedMap[V]) []mapEntry[V] {
+	var res []mapEntry[V]
+
+	for _, m := range maps {
+		res = append(res, m.entries()...)
+	}
+
+	sort.Slice(res, func(i, j int) bool { return res[i].key < res[j].key })
+
+	return res
+}
+
+// cowUpdate stores in p change's copy of what p points to, so readers
+// can Load it with no lock.  change must not modify its argument,
+// which readers may still have, and callers serialise their updates.
+func cowUpdate[T any](p *atomic.Pointer[T], change func(old T) T) {
+	var old T
+	if cur := p.Load(); cur != nil {
+		old = *cur
+	}
+
+	res := change(old)
+	p.Store(&res)
+}
+
+// cowSet is cowUpdate setting m[k] = v in a map.
+func cowSet[K comparable, V any](p *atomic.Pointer[map[K]V], k K, v V) {
+	cowUpdate(p, func(old map[K]V) map[K]V {
+		m := make(map[K]V, len(old)+1)
+		maps.Copy(m, old)
+		m[k] = v
+
+		return m
+	})
+}
@@ -0,0 +1,129 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCounterStriping(t *testing.T) {
+	defer func(n int) { numStripes = n }(numStripes)
+
+	numStripes = 4
+
+	c := newCounter()
+	c.add(5)
+
+	if c.stripes.Load() != nil {
+		t.Errorf("Expected one cell until contended")
+	}
+
+	if !c.stripe() || len(*c.stripes.Load()) != 4 {
+		t.Fatalf("Expected 4 stripes")
+	}
+
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for range 1000 {
+				c.add(1)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if n := c.load(); n != 8005 {
+		t.Errorf("Expected the cell and stripes to add up to 8005 got %d", n)
+	}
+
+	c.zero()
+
+	if n := c.load(); n != 0 {
+		t.Errorf("Expected 0 after zero got %d", n)
+	}
+
+	numStripes = 1
+
+	if newCounter().stripe() {
+		t.Errorf("Expected no striping with one P")
+	}
+}
+
+func TestShardedMap(t *testing.T) {
+	m := newShardedMap[int]()
+
+	for i := range 100 {
+		m.getOrMake(strconv.Itoa(i), func() int { return i })
+	}
+
+	if v := m.getOrMake("7", func() int { return -1 }); v != 7 {
+		t.Errorf("Expected the existing 7 got %d", v)
+	}
+
+	if _, ok := m.getOrTryMake("new", func() (int, bool) { return 0, false }); ok {
+		t.Errorf("Expected nothing stored when mk says no")
+	}
+
+	if v, ok := m.delete("7"); !ok || v != 7 {
+		t.Errorf("Expected to delete 7 got %d %v", v, ok)
+	}
+
+	if m.len() != 99 || len(m.entries()) != 99 {
+		t.Errorf("Expected 99 got %d %d", m.len(), len(m.entries()))
+	}
+
+	m.reset()
+
+	if m.len() != 0 || len(m.entries()) != 0 {
+		t.Errorf("Expected empty after reset")
+	}
+}
+
+// TestManyNames checks making a name doesn't get slower with the
+// number of names: the second 50k shouldn't take much longer than
+// the first.
+func TestManyNames(t *testing.T) {
+	r := NewRegistry()
+
+	fill := func(from int) time.Duration {
+		start := time.Now()
+
+		for i := from; i < from+50000; i++ {
+			r.IncrDeltaSyncSuffix("many_"+strconv.Itoa(i), 1, "a")
+		}
+
+		return time.Since(start)
+	}
+
+	first, second := fill(0), fill(50000)
+	if second > 4*first+100*time.Millisecond {
+		t.Errorf("Expected linear making names got %v then %v", first, second)
+	}
+}
+
+// TestCowSet checks an update leaves the map readers have alone.
+func TestCowSet(t *testing.T) {
+	var p atomic.Pointer[map[string]int]
+
+	cowSet(&p, "a", 1)
+	before := p.Load()
+	cowSet(&p, "b", 2)
+
+	if len(*before) != 1 || (*before)["a"] != 1 {
+		t.Errorf("Expected the old map unchanged got %v", *before)
+	}
+
+	if m := *p.Load(); len(m) != 2 || m["a"] != 1 || m["b"] != 2 {
+		t.Errorf("Expected a and b got %v", m)
+	}
+}
@@ -0,0 +1,64 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+// this suffix.go file controls whether the caller suffix splits a
+// counter, value, meta counter or distribution into a per caller
+// breakdown.
+
+// SuffixMode says what to do with the suffix (caller function name or
+// the one passed to the ...Suffix APIs) of a name.
+type SuffixMode int
+
+const (
+	// Merged ignores the suffix and keeps just one total per name (the default).
+	Merged SuffixMode = iota
+	// PerCaller keeps the merged total and also a name/suffix entry
+	// per suffix seen.
+	PerCaller
+)
+
+// String returns the mode for logging.
+func (m SuffixMode) String() string {
+	if m == PerCaller {
+		return "PerCaller"
+	}
+
+	return "Merged"
+}
+
+// SetSuffixMode sets the suffix mode for one counter, value, meta
+// counter or distribution name.  It only affects increments and sets
+// done after the call.
+func (r *Registry) SetSuffixMode(name string, m SuffixMode) {
+	r.ctxLock.Lock()
+	cowSet(&r.suffixModes, name, m) // so the hot path reads it with no lock
+	r.ctxLock.Unlock()
+}
+
+// SetDefaultSuffixMode sets the suffix mode for every name without
+// its own SetSuffixMode.
+func (r *Registry) SetDefaultSuffixMode(m SuffixMode) {
+	r.defaultSuffixMode.Store(int32(m))
+}
+
+// suffixKey returns the name/suffix key for the per caller entry or
+// "" if name is Merged.
+func (r *Registry) suffixKey(name string, suffix string) string {
+	if r.suffixMode(name) != PerCaller || suffix == "" {
+		return ""
+	}
+
+	return name + "/" + suffix // will malloc
+}
+
+// suffixMode returns the mode in effect for name.
+func (r *Registry) suffixMode(name string) SuffixMode {
+	if modes := r.suffixModes.Load(); modes != nil {
+		if m, ok := (*modes)[name]; ok {
+			return m
+		}
+	}
+
+	return SuffixMode(r.defaultSuffixMode.Load())
+}
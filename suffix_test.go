@@ -0,0 +1,115 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"context"
+	"testing"
+)
+
+func callerA(r *Registry) {
+	r.IncrSync("per_caller")
+	r.IncrSync("merged")
+}
+
+func callerB(r *Registry) {
+	r.IncrDeltaSync("per_caller", 2)
+	r.IncrDeltaSync("merged", 2)
+}
+
+func TestSuffixModePerCaller(t *testing.T) {
+	r := NewRegistry()
+	r.SetSuffixMode("per_caller", PerCaller)
+
+	callerA(r)
+	callerB(r)
+
+	if got := r.ReadSync("per_caller"); got != 3 {
+		t.Errorf("Expected merged total 3, got %d", got)
+	}
+
+	if got := r.ReadSync("per_caller/callerA"); got != 1 {
+		t.Errorf("Expected 1 for callerA, got %d", got)
+	}
+
+	if got := r.ReadSync("per_caller/callerB"); got != 2 {
+		t.Errorf("Expected 2 for callerB, got %d", got)
+	}
+
+	if got := r.ReadSync("merged"); got != 3 {
+		t.Errorf("Expected merged total 3, got %d", got)
+	}
+
+	if _, split := r.counters.get("merged/callerA"); split {
+		t.Errorf("Merged counter should not be split by caller")
+	}
+
+	r.LogCounters()
+}
+
+func TestSuffixModeValuesAndMeta(t *testing.T) {
+	r := NewRegistry()
+	r.SetDefaultSuffixMode(PerCaller)
+
+	r.getOrMakeAndSetValue("temp", "a", 1.5)
+	r.getOrMakeAndSetValue("temp", "b", 2.5)
+
+	mv, _ := r.valuesByName.get("temp")
+	av, _ := r.values.get("temp/a")
+	merged, a := mv.data, av.data
+
+	if merged != 2.5 || a != 1.5 {
+		t.Errorf("Expected merged 2.5 and a 1.5, got %f %f", merged, a)
+	}
+
+	r.AddMetaCounter("availability", "good", "bad", RatioTotal)
+	r.IncrDeltaSyncSuffix("good", 9, "svc1")
+	r.IncrDeltaSyncSuffix("bad", 1, "svc1")
+	r.IncrDeltaSyncSuffix("good", 1, "svc2")
+
+	r.ctxLock.RLock()
+	suffixes := r.metaSuffixes(r.metaCtrs["availability"])
+	r.ctxLock.RUnlock()
+
+	if len(suffixes) != 2 || suffixes[0] != "svc1" || suffixes[1] != "svc2" {
+		t.Errorf("Expected meta suffixes svc1 and svc2, got %v", suffixes)
+	}
+
+	r.LogCounters()
+}
+
+func TestSuffixModeDistribution(t *testing.T) {
+	r := NewRegistry()
+	r.SetResolution(HighRes)
+	r.SetSuffixMode("lat", PerCaller)
+
+	r.MarkDistributionSyncSuffix("lat", 5, "a")
+	r.MarkDistributionSuffix("lat", 5, "b")
+	r.MarkDistributionExemplar("lat", 5, L("trace_id", "t1"))
+	r.MarkDistributionSyncSuffix("lat_merged", 5, "a")
+
+	if err := r.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown failed %v", err)
+	}
+
+	got := map[string]int64{}
+
+	for _, d := range r.Snapshot().Distributions {
+		for _, b := range d.Buckets {
+			got[d.Name+"/"+d.Suffix] += b.Total
+		}
+	}
+
+	want := map[string]int64{
+		"lat/": 3, "lat/a": 1, "lat/b": 1, "lat/TestSuffixModeDistribution": 1, "lat_merged/": 1,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %v got %v", want, got)
+	}
+
+	for k, n := range want {
+		if got[k] != n {
+			t.Errorf("Expected %d for %s got %v", n, k, got)
+		}
+	}
+}
@@ -13,27 +13,28 @@ type TimeFunc func()
 
 // TimeFuncRun runs the function and then
 // marks it in a histogram.
-func TimeFuncRun(name string, f TimeFunc) {
+func (r *Registry) TimeFuncRun(name string, f TimeFunc) {
 	start := time.Now()
 
 	f()
 
 	end := time.Now()
 
-	MarkDistribution(name,
-		end.Sub(start).Seconds())
+	r.MarkDistributionSuffix(name,
+		end.Sub(start).Seconds(),
+		getCallerFunctionName())
 }
 
 // TimeFuncRunSuffix runs the function and then
 // marks it in a histogram.
-func TimeFuncRunSuffix(name string, f TimeFunc, suffix string) {
+func (r *Registry) TimeFuncRunSuffix(name string, f TimeFunc, suffix string) {
 	start := time.Now()
 
 	f()
 
 	end := time.Now()
 
-	MarkDistributionSuffix(name,
+	r.MarkDistributionSuffix(name,
 		end.Sub(start).Seconds(),
 		suffix)
 }
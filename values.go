@@ -7,29 +7,207 @@ package counters
 import (
 	"log"
 	"strings"
+	"time"
 )
 
 // Set is the main value API - will create value metric, and get the
 // caller func for suffix, as needed.  One line does it all.
-func Set(name string, val float64) {
-	suffix := getCallerFunctionName()
-	SetSuffix(name, val, suffix)
+func (r *Registry) Set(name string, val float64) {
+	r.SetSuffix(name, val, getCallerFunctionName())
 }
 
 // SetSuffix is a bit faster API - the func name lookup is a bit slow.
-func SetSuffix(name string, val float64, suffix string) {
-	j := getChannel()
-	select { // non-blocking will drop overflow
-	case theCtx.v[j] <- valueMsg{name, suffix, val}:
+func (r *Registry) SetSuffix(name string, val float64, suffix string) {
+	r.sendValue(valueMsg{name: name, suffix: suffix, v: val})
+}
+
+// AddValue adds delta to the value name, making it a float
+// accumulator; the value reported in ValueLast mode is the running
+// total and the other modes aggregate the deltas.
+func (r *Registry) AddValue(name string, delta float64) {
+	r.AddValueSuffix(name, delta, getCallerFunctionName())
+}
+
+// AddValueSuffix is AddValue with the suffix given.
+func (r *Registry) AddValueSuffix(name string, delta float64, suffix string) {
+	r.sendValue(valueMsg{name: name, suffix: suffix, v: delta, add: true})
+}
+
+// sendValue queues vm for the reading go routines.
+func (r *Registry) sendValue(vm valueMsg) {
+	if !r.accepting.Load() {
+		return
+	}
+
+	j := r.getChannel()
+	select { // non-blocking, overflow handled per SetOverflowPolicy
+	case r.v[j] <- vm:
 		// good
-	default: // bad but ok
+	default:
+		r.overflowValue(j, vm)
 	}
 }
 
-func logValue(name string, mc *value) {
-	fmtString := strings.ReplaceAll(theCtx.fmtString, "d", "f") // fragile
-	log.Printf(fmtString,
+func (r *Registry) logValue(name string, data float64, oldData float64, mode ValueMode, rus []Rollup) {
+	if mode != ValueLast {
+		name += " (" + mode.String() + ")"
+	}
+
+	fmtString := strings.ReplaceAll(r.fmtString, "d", "f") // fragile
+	log.Printf(strings.TrimSuffix(fmtString, "\n")+"%s%s\n",
 		name,
-		mc.data,
-		mc.data-mc.oldData)
+		data,
+		data-oldData,
+		noRateColumns,
+		rollupColumns(rus, false))
+}
+
+// getOrMakeValue returns the value at key in m, making it for name
+// and its suffix or labels in the mode set for name if it isn't there.
+func (r *Registry) getOrMakeValue(m *shardedMap[*value], key string, name string, suffix string, labels []Label) *value {
+	if v, ok := m.get(key); ok {
+		return v
+	}
+
+	return r.valueFor(m, key, func() *value {
+		return makeValue(name, suffix, labels, r.valueMode(name))
+	})
+}
+
+func makeValue(name string, suffix string, labels []Label, mode ValueMode) *value {
+	return &value{
+		name:      name,
+		suffix:    suffix,
+		labels:    labels,
+		mode:      mode,
+		firstSeen: time.Now(),
+	}
+}
+
+func (v *value) set(f float64) {
+	v.observe(f, false)
+}
+
+// observe records a Set of f or if add an AddValue of f.
+func (v *value) observe(f float64, add bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	if add {
+		v.data += f
+	} else {
+		v.data = f
+	}
+
+	if v.N == 0 {
+		v.min, v.max = f, f
+	} else {
+		v.min = min(v.min, f)
+		v.max = max(v.max, f)
+	}
+
+	v.sum += f
+	v.N++
+	v.lastUpdated = time.Now()
+}
+
+// current returns the value for the interval so far per the mode;
+// min, max and mean of no observations hold the last value.  Must
+// hold v.mu.
+func (v *value) current() float64 {
+	switch v.mode {
+	case ValueSum:
+		return v.sum
+	case ValueCount:
+		return v.N
+	case ValueMin:
+		if v.N > 0 {
+			return v.min
+		}
+	case ValueMax:
+		if v.N > 0 {
+			return v.max
+		}
+	case ValueMean:
+		if v.N > 0 {
+			return v.sum / v.N
+		}
+	case ValueLast:
+	}
+
+	return v.data
+}
+
+// interval returns the value for the interval and starts the next
+// one.  Must hold v.mu.
+func (v *value) interval() float64 {
+	res := v.current()
+	v.N, v.sum = 0, 0
+
+	return res
+}
+
+func (v *value) zero() {
+	v.mu.Lock()
+	v.data, v.oldData, v.N, v.sum = 0, 0, 0, 0
+	v.mu.Unlock()
+}
+
+// ValueMode is how the Sets of a value in one interval are boiled
+// down to the one number reported.
+type ValueMode int
+
+const (
+	// ValueLast reports the last value set (the default).
+	ValueLast ValueMode = iota
+	// ValueSum reports the sum of the values set.
+	ValueSum
+	// ValueMin reports the smallest value set.
+	ValueMin
+	// ValueMax reports the largest value set.
+	ValueMax
+	// ValueMean reports the mean of the values set.
+	ValueMean
+	// ValueCount reports how many times the value was set.
+	ValueCount
+)
+
+var valueModeNames = []string{"last", "sum", "min", "max", "mean", "count"}
+
+// String returns the mode's name as shown in LogCounters.
+func (m ValueMode) String() string {
+	if m < 0 || int(m) >= len(valueModeNames) {
+		return "unknown"
+	}
+
+	return valueModeNames[m]
+}
+
+// SetValueMode sets how the value name (and its breakdowns) is
+// aggregated each interval, for existing and future values.
+func (r *Registry) SetValueMode(name string, m ValueMode) {
+	r.ctxLock.Lock()
+	cowSet(&r.valueModes, name, m) // so making a value reads it with no lock
+	r.ctxLock.Unlock()
+
+	_, vals := r.keysFor(name)
+
+	for _, k := range vals {
+		for _, vm := range []*shardedMap[*value]{r.valuesByName, r.values} {
+			if v, ok := vm.get(k); ok {
+				v.mu.Lock()
+				v.mode = m
+				v.mu.Unlock()
+			}
+		}
+	}
+}
+
+// valueMode returns the mode set for name.
+func (r *Registry) valueMode(name string) ValueMode {
+	if modes := r.valueModes.Load(); modes != nil {
+		return (*modes)[name]
+	}
+
+	return ValueLast
 }
@@ -0,0 +1,84 @@
+// -*- tab-width: 2 -*-
+
+package counters
+
+import (
+	"testing"
+)
+
+func TestValueModes(t *testing.T) {
+	r := NewRegistry()
+
+	var reports []ValReport
+
+	r.SetValReporter(func(metrics []ValReport) {
+		reports = metrics
+	})
+
+	modes := map[string]ValueMode{
+		"v_last":  ValueLast,
+		"v_sum":   ValueSum,
+		"v_min":   ValueMin,
+		"v_max":   ValueMax,
+		"v_mean":  ValueMean,
+		"v_count": ValueCount,
+	}
+
+	for name, m := range modes {
+		r.SetValueMode(name, m)
+
+		for _, f := range []float64{4, 1, 7} {
+			r.getOrMakeAndSetValue(name, "test", f)
+		}
+	}
+
+	r.SetValueMode("v_acc", ValueLast)
+	r.getOrMakeAndObserveValue("v_acc", "test", 1.5, true)
+	r.getOrMakeAndObserveValue("v_acc", "test", 2.5, true)
+
+	logAndFlush(t, r)
+
+	expected := map[string]float64{
+		"v_last":  7,
+		"v_sum":   12,
+		"v_min":   1,
+		"v_max":   7,
+		"v_mean":  4,
+		"v_count": 3,
+		"v_acc":   4,
+	}
+
+	for _, rep := range reports {
+		want, ok := expected[rep.Name]
+		if !ok {
+			continue
+		}
+
+		if rep.Value != want {
+			t.Errorf("Expected %s to be %f, got %f", rep.Name, want, rep.Value)
+		}
+
+		if m, ok := modes[rep.Name]; ok && rep.Mode != m {
+			t.Errorf("Expected %s in mode %s, got %s", rep.Name, m, rep.Mode)
+		}
+
+		delete(expected, rep.Name)
+	}
+
+	if len(expected) != 0 {
+		t.Errorf("Values not reported %v", expected)
+	}
+
+	// next interval with no sets: sum and count go to 0, the rest hold
+	logAndFlush(t, r)
+
+	for _, rep := range reports {
+		if rep.Name == "v_sum" && (rep.Value != 0 || rep.Delta != -12) {
+			t.Errorf("Expected empty interval sum of 0, got %v", rep)
+		}
+
+		if rep.Name == "v_max" && rep.Value != 7 {
+			t.Errorf("Expected max to hold at 7, got %v", rep)
+		}
+	}
+}